@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// analyzers gives the command line for every supported static analyzer. checks
+// are the checks to enable (the default of the analyzer, if empty), files the
+// sources to analyze. The analyzers must print their findings in the format
+// matched by diagRegexp
+var analyzers = map[string]func(checks string, files []string) []string{
+	"clang-tidy": func(checks string, files []string) []string {
+		args := []string{"clang-tidy", "--quiet"}
+		if checks != "" {
+			args = append(args, "--checks="+checks)
+		}
+		// There is no compilation database, so we give an empty list
+		// of compiler flags
+		return append(append(args, files...), "--")
+	},
+	"cppcheck": func(checks string, files []string) []string {
+		args := []string{"cppcheck", "--quiet", "--template={file}:{line}:{column}: {severity}: {message} [{id}]"}
+		if checks != "" {
+			args = append(args, "--enable="+checks)
+		}
+		return append(args, files...)
+	},
+}
+
+// defaultFail are the severities of findings, that fail a test, if the suite
+// does not give any
+var defaultFail = []string{"fatal error", "error", "warning"}
+
+// runAnalyze runs the static analyzer of spec in the make-sandbox over the
+// files given in Link and creates a test for every finding. Notes are added to
+// the finding they belong to. If there are no findings, there is a single
+// passing test
+func (r *runner) runAnalyze(spec Suite) cmdResult {
+	var res cmdResult
+
+	var files []string
+	for _, f := range spec.Link {
+		files = append(files, f+".cpp")
+	}
+	args := analyzers[spec.Analyzer](spec.Checks, files)
+
+	ctx, cancel := context.WithTimeout(r.ctx, conf.MakeTimeout)
+	defer cancel()
+	cmd := sandbox.CommandContext(ctx, conf.MakeSandbox, args[0], args[1:]...)
+	cmd.SetDir(r.builddir)
+	out, err := sandbox.CombinedOutput(cmd)
+	diags := parseDiagnostics(string(out))
+
+	// Analyzers may exit with an error, if they found something. It is
+	// only a failure, if they did not report anything
+	if err != nil && (len(diags) == 0 || ctx.Err() != nil) {
+		res.output = out
+		res.err = err
+		return res
+	}
+	if err == nil {
+		res.stats.UserTime = cmd.ProcessState().UserTime()
+		res.stats.SystemTime = cmd.ProcessState().SystemTime()
+	}
+
+	fail := spec.Fail
+	if len(fail) == 0 {
+		fail = defaultFail
+	}
+
+	suite := &Testsuite{Ok: true}
+	var last *tap.Testline
+	for _, d := range diags {
+		if d.Severity == "note" && last != nil {
+			last.Diagnostic += d.Text
+			continue
+		}
+
+		// clang-tidy gives absolute paths
+		file := d.File
+		if path.Dir(file) == path.Clean(r.builddir) {
+			file = path.Base(file)
+		}
+
+		last = &tap.Testline{
+			Num:         uint(len(suite.Tests) + 1),
+			Ok:          !contains(fail, d.Severity),
+			Description: fmt.Sprintf("%s:%d: %s: %s", file, d.Line, d.Severity, d.Message),
+			Diagnostic:  strings.Replace(d.Text, path.Clean(r.builddir)+"/", "", -1),
+			Yaml:        formatYAML(map[string]string{"file": file, "line": strconv.Itoa(d.Line)}),
+		}
+		suite.Ok = suite.Ok && last.Ok
+		suite.Tests = append(suite.Tests, last)
+	}
+	if len(suite.Tests) == 0 {
+		suite.Tests = append(suite.Tests, &tap.Testline{Num: 1, Ok: true, Description: spec.Analyzer})
+	}
+
+	res.suite = suite
+	return res
+}
+
+// contains reports whether s is in list
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
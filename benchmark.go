@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// Benchmark describes how to measure the performance of the program of a
+// benchmark testsuite. The medians of the CPU and wall time of all runs, minus
+// the overhead of the sandbox, are compared against the given maximums and the
+// medians of a reference solution times Factor. Without either, the times are
+// only reported
+type Benchmark struct {
+	Runs      int      `json:"runs,omitempty"`      // Default 5
+	Input     string   `json:"input,omitempty"`     // A file of the request, given on stdin in every run
+	CPU       string   `json:"cpu,omitempty"`       // Maximum CPU time, e.g. "500ms"
+	Wall      string   `json:"wall,omitempty"`      // Maximum wall time
+	Reference []string `json:"reference,omitempty"` // Files to link into a reference solution, run alternating with the program
+	Factor    float64  `json:"factor,omitempty"`    // Maximum ratio of the times of the program and the reference. Default 2
+}
+
+// timing are the times measured in the runs of a program
+type timing struct {
+	cpu, wall []time.Duration
+}
+
+// median returns the median of d, which is sorted in the process
+func median(d []time.Duration) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	if len(d)%2 == 1 {
+		return d[len(d)/2]
+	}
+	return (d[len(d)/2-1] + d[len(d)/2]) / 2
+}
+
+// runBenchmark runs the program of spec (and the reference solution, if any)
+// the given number of times and creates a test each for the CPU and the wall
+// time. A failing run fails the whole suite
+func (r *runner) runBenchmark(spec Suite) cmdResult {
+	var res cmdResult
+	b := spec.Benchmark
+
+	runs := b.Runs
+	if runs <= 0 {
+		runs = 5
+	}
+	factor := b.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+	var max [2]time.Duration
+	for i, s := range []string{b.CPU, b.Wall} {
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			res.err = fmt.Errorf("Could not parse duration: %s", s)
+			return res
+		}
+		max[i] = d
+	}
+
+	// The overhead of the sandbox (helper processes, cgroups, tracers…) is
+	// measured by running true in it alternating with the program
+	var prog, ref, base timing
+	for i := 0; i < runs; i++ {
+		_, st, wall, err := r.timeRun("true", "")
+		if err != nil {
+			res.err = fmt.Errorf("Could not measure sandbox overhead: %v", err)
+			return res
+		}
+		base.cpu = append(base.cpu, st.UserTime+st.SystemTime)
+		base.wall = append(base.wall, wall)
+
+		out, st, wall, err := r.timeRun(path.Join(r.builddir, spec.Name), b.Input)
+		res.stats.add(st)
+		if err != nil {
+			res.output = out
+			res.err = err
+			return res
+		}
+		prog.cpu = append(prog.cpu, st.UserTime+st.SystemTime)
+		prog.wall = append(prog.wall, wall)
+
+		if len(b.Reference) == 0 {
+			continue
+		}
+		out, st, wall, err = r.timeRun(path.Join(r.builddir, spec.Name+".reference"), b.Input)
+		if err != nil {
+			res.output = out
+			res.err = fmt.Errorf("Reference solution failed: %v", err)
+			return res
+		}
+		ref.cpu = append(ref.cpu, st.UserTime+st.SystemTime)
+		ref.wall = append(ref.wall, wall)
+	}
+
+	suite := &Testsuite{Ok: true}
+	for i, name := range []string{"CPU time", "Wall time"} {
+		times, reftimes, overhead := prog.cpu, ref.cpu, median(base.cpu)
+		if i == 1 {
+			times, reftimes, overhead = prog.wall, ref.wall, median(base.wall)
+		}
+		subtract(times, overhead)
+		subtract(reftimes, overhead)
+		all := formatDurations(times)
+		m := median(times)
+
+		// The limit is the stricter one of the maximum and the reference
+		limit := max[i]
+		var diag bytes.Buffer
+		fmt.Fprintf(&diag, "Median: %v (runs: %s)\n", m, all)
+		fmt.Fprintf(&diag, "Sandbox overhead: %v (subtracted)\n", overhead)
+		if max[i] > 0 {
+			fmt.Fprintf(&diag, "Maximum: %v\n", max[i])
+		}
+		if len(reftimes) > 0 {
+			rm := median(reftimes)
+			rl := time.Duration(float64(rm) * factor)
+			fmt.Fprintf(&diag, "Reference: %v × %g = %v\n", rm, factor, rl)
+			if limit == 0 || rl < limit {
+				limit = rl
+			}
+		}
+
+		tl := &tap.Testline{Num: uint(i + 1), Description: name, Ok: limit == 0 || m <= limit, Diagnostic: diag.String()}
+		y := map[string]string{"actual": m.String()}
+		if limit > 0 {
+			y["expected"] = "<= " + limit.String()
+		}
+		if i == 1 {
+			y["duration_us"] = strconv.FormatInt(int64(m/time.Microsecond), 10)
+		}
+		tl.Yaml = formatYAML(y)
+		suite.Ok = suite.Ok && tl.Ok
+		suite.Tests = append(suite.Tests, tl)
+	}
+
+	res.suite = suite
+	return res
+}
+
+// subtract subtracts o from all durations in d, without going below zero
+func subtract(d []time.Duration, o time.Duration) {
+	for i := range d {
+		if d[i] -= o; d[i] < 0 {
+			d[i] = 0
+		}
+	}
+}
+
+// timeRun runs the program prog in the test-sandbox, with the file input (if
+// not empty) on stdin and returns its stderr, its stats and the wall time it
+// took. Its stdout is discarded
+func (r *runner) timeRun(prog, input string) (stderr []byte, st stats, wall time.Duration, err error) {
+	var errbuf bytes.Buffer
+	cmd, cancel := r.command(conf.TestTimeout, prog)
+	defer cancel()
+	cmd.SetStdout(ioutil.Discard)
+	cmd.SetStderr(&errbuf)
+
+	if input != "" {
+		in, err := os.Open(path.Join(r.builddir, input))
+		if err != nil {
+			return nil, st, 0, err
+		}
+		defer in.Close()
+		cmd.SetStdin(in)
+	}
+
+	start := time.Now()
+	err = cmd.Run()
+	wall = time.Since(start)
+	if ev, ok := sandbox.Events(cmd); ok && ev.Any() {
+		st.Limits = &ev
+	}
+	if err != nil {
+		return errbuf.Bytes(), st, wall, err
+	}
+	st.UserTime = cmd.ProcessState().UserTime()
+	st.SystemTime = cmd.ProcessState().SystemTime()
+	return errbuf.Bytes(), st, wall, nil
+}
+
+// formatDurations formats a list of durations, separated by commas
+func formatDurations(d []time.Duration) string {
+	var s []string
+	for _, x := range d {
+		s = append(s, x.String())
+	}
+	return strings.Join(s, ", ")
+}
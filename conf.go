@@ -15,11 +15,13 @@ type Conf struct {
 	TAPListener      string
 	MakeSandbox      string
 	TestSandbox      string
+	CheckerSandbox   string
 	TCPListen        string
 	NumConns         int
 	Linger           int
 	MakeTimeout      time.Duration
 	TestTimeout      time.Duration
+	MemcheckTimeout  time.Duration
 }
 
 var (
@@ -30,11 +32,13 @@ var (
 		"/usr/share/bor/TAPListener.cpp",
 		"plain",
 		"easysandbox",
+		"plain",
 		"localhost:7066",
 		10,
 		5,
 		5 * time.Second,
 		time.Second,
+		10 * time.Second,
 	}
 	confpath = flag.String("config", "/etc/bor.conf", "Config path")
 )
@@ -63,6 +67,9 @@ func ReadConfig() error {
 	if str, err := cfg.GetString("default", "TestSandbox"); err == nil {
 		conf.TestSandbox = str
 	}
+	if str, err := cfg.GetString("default", "CheckerSandbox"); err == nil {
+		conf.CheckerSandbox = str
+	}
 	if str, err := cfg.GetString("default", "TCPListen"); err == nil {
 		conf.TCPListen = str
 	} else {
@@ -88,6 +95,13 @@ func ReadConfig() error {
 		}
 		conf.TestTimeout = to
 	}
+	if str, err := cfg.GetString("default", "MemcheckTimeout"); err == nil {
+		to, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("Could not parse duration: %s", str)
+		}
+		conf.MemcheckTimeout = to
+	}
 
 	if err = sandbox.Config(cfg); err != nil {
 		return err
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// Coverage requests the code coverage of some files of the request by all
+// testsuites. Everything is built with --coverage for this
+type Coverage struct {
+	Files    []string `json:"files"`              // Without .cpp-extension, like Suite.Link
+	Annotate bool     `json:"annotate,omitempty"` // Whether to return the sources, annotated with the execution counts
+}
+
+// fileCoverage is the coverage of a single source file
+type fileCoverage struct {
+	File            string `json:"file"`
+	Lines           int    `json:"lines"`
+	LinesCovered    int    `json:"lines_covered"`
+	Branches        int    `json:"branches"`
+	BranchesCovered int    `json:"branches_covered"`
+	Uncovered       []int  `json:"uncovered,omitempty"` // The lines never executed
+	Annotated       string `json:"annotated,omitempty"` // Only if requested, in the format of gcov
+
+	counts map[int]int64
+}
+
+// gcovOutput is the JSON intermediate format of gcov (gcov -j). Only the parts
+// we need are parsed
+type gcovOutput struct {
+	Files []struct {
+		File  string `json:"file"`
+		Lines []struct {
+			LineNumber int   `json:"line_number"`
+			Count      int64 `json:"count"`
+			Branches   []struct {
+				Count int64 `json:"count"`
+				Throw bool  `json:"throw"`
+			} `json:"branches"`
+		} `json:"lines"`
+	} `json:"files"`
+}
+
+// runCoverage runs gcov in the make-sandbox, after all testsuites ran, and
+// creates a suite named "Coverage" with a test for every file. The tests only
+// fail, if no coverage data could be found for a file
+func (r *runner) runCoverage(cov *Coverage) suiteWrap {
+	wrap := suiteWrap{Name: "Coverage", Suite: Testsuite{Ok: true}}
+
+	var objs []string
+	for _, f := range cov.Files {
+		objs = append(objs, f+".o")
+	}
+	args := append([]string{"--json-format", "--stdout", "--branch-counts"}, objs...)
+
+	ctx, cancel := context.WithTimeout(r.ctx, conf.MakeTimeout)
+	defer cancel()
+	cmd := sandbox.CommandContext(ctx, conf.MakeSandbox, "gcov", args...)
+	cmd.SetDir(r.builddir)
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+	out, err := cmd.Output()
+	if err != nil {
+		wrap.Error = err.Error()
+		wrap.Output = stderr.String()
+		return wrap
+	}
+
+	files, err := parseGcov(out)
+	if err != nil {
+		wrap.Error = err.Error()
+		return wrap
+	}
+
+	for i, f := range cov.Files {
+		name := f + ".cpp"
+		tl := &tap.Testline{Num: uint(i + 1), Description: name}
+		wrap.Suite.Tests = append(wrap.Suite.Tests, tl)
+
+		fc, ok := files[name]
+		if !ok {
+			tl.Diagnostic = "No coverage data"
+			wrap.Suite.Ok = false
+			continue
+		}
+		tl.Ok = true
+		tl.Diagnostic = fmt.Sprintf("Lines: %s, branches: %s", percent(fc.LinesCovered, fc.Lines), percent(fc.BranchesCovered, fc.Branches))
+		if cov.Annotate {
+			fc.Annotated = annotate(r.msg.Files[name].b, fc.counts)
+		}
+		wrap.Coverage = append(wrap.Coverage, *fc)
+	}
+	return wrap
+}
+
+// parseGcov parses the output of gcov --json-format --stdout, which is a JSON
+// document per object file. The counts of files occuring in more than one
+// document (e.g. headers) are added up. Exceptional branches are ignored
+func parseGcov(out []byte) (map[string]*fileCoverage, error) {
+	files := make(map[string]*fileCoverage)
+	branches := make(map[string]map[int][]int64)
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var g gcovOutput
+		if err := dec.Decode(&g); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Could not parse output of gcov: %v", err)
+		}
+
+		for _, f := range g.Files {
+			fc, ok := files[f.File]
+			if !ok {
+				fc = &fileCoverage{File: f.File, counts: make(map[int]int64)}
+				files[f.File] = fc
+				branches[f.File] = make(map[int][]int64)
+			}
+			for _, l := range f.Lines {
+				fc.counts[l.LineNumber] += l.Count
+
+				// Branches are added up by their index, a line has as
+				// many branches as in any document
+				bs := branches[f.File][l.LineNumber]
+				i := 0
+				for _, b := range l.Branches {
+					if b.Throw {
+						continue
+					}
+					if i < len(bs) {
+						bs[i] += b.Count
+					} else {
+						bs = append(bs, b.Count)
+					}
+					i++
+				}
+				branches[f.File][l.LineNumber] = bs
+			}
+		}
+	}
+
+	for name, fc := range files {
+		for l, n := range fc.counts {
+			fc.Lines++
+			if n > 0 {
+				fc.LinesCovered++
+			} else {
+				fc.Uncovered = append(fc.Uncovered, l)
+			}
+		}
+		sort.Ints(fc.Uncovered)
+		for _, bs := range branches[name] {
+			for _, n := range bs {
+				fc.Branches++
+				if n > 0 {
+					fc.BranchesCovered++
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// annotate prefixes every line of src with its execution count, like gcov
+// does: "-" for lines without code and "#####" for lines never executed
+func annotate(src []byte, counts map[int]int64) string {
+	var buf bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for n := 1; s.Scan(); n++ {
+		c := "-"
+		if cnt, ok := counts[n]; ok && cnt == 0 {
+			c = "#####"
+		} else if ok {
+			c = strconv.FormatInt(cnt, 10)
+		}
+		fmt.Fprintf(&buf, "%9s:%5d:%s\n", c, n, s.Text())
+	}
+	return buf.String()
+}
+
+// percent formats the fraction n/total, e.g. "3/4 (75.0%)"
+func percent(n, total int) string {
+	if total == 0 {
+		return "0/0"
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", n, total, 100*float64(n)/float64(total))
+}
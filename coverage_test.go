@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGcov(t *testing.T) {
+	// Two objects including the same header, where the line 3 has a
+	// different number of branches in each (e.g. template instantiations)
+	out := `{"files": [
+	{"file": "a.h", "lines": [
+		{"line_number": 2, "count": 1, "branches": []},
+		{"line_number": 3, "count": 1, "branches": [{"count": 1, "throw": false}, {"count": 0, "throw": false}]}
+	]},
+	{"file": "a.cpp", "lines": [
+		{"line_number": 1, "count": 0, "branches": [{"count": 0, "throw": false}, {"count": 3, "throw": true}]}
+	]}
+]}
+{"files": [
+	{"file": "a.h", "lines": [
+		{"line_number": 2, "count": 0, "branches": []},
+		{"line_number": 3, "count": 2, "branches": [{"count": 0, "throw": true}, {"count": 0, "throw": false}, {"count": 0, "throw": false}, {"count": 1, "throw": false}]},
+		{"line_number": 4, "count": 0, "branches": []}
+	]}
+]}`
+	files, err := parseGcov([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]fileCoverage{
+		"a.h":   {File: "a.h", Lines: 3, LinesCovered: 2, Branches: 3, BranchesCovered: 2, Uncovered: []int{4}},
+		"a.cpp": {File: "a.cpp", Lines: 1, LinesCovered: 0, Branches: 1, BranchesCovered: 0, Uncovered: []int{1}},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("parseGcov returned %d files, want %d", len(files), len(want))
+	}
+	for name, w := range want {
+		fc, ok := files[name]
+		if !ok {
+			t.Errorf("parseGcov did not return %s", name)
+			continue
+		}
+		got := *fc
+		got.counts = nil
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("parseGcov: %s = %+v, want %+v", name, got, w)
+		}
+	}
+	if _, err := parseGcov([]byte("{")); err == nil {
+		t.Error("parseGcov did not fail on invalid JSON")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+// diagnostic is a message of the compiler (or a similar tool), referring to a
+// location in a source file
+type diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity string
+	Message  string
+	Text     string // The complete output of the diagnostic, up to the next one
+}
+
+// diagRegexp matches diagnostics in the format used by gcc and clang, e.g.
+// "exercise2.cpp:5:12: error: expected ';' before '}' token". The severities of
+// cppcheck are matched too
+var diagRegexp = regexp.MustCompile(`(?m)^([^:\n]+):(\d+):(?:(\d+):)? (fatal error|error|warning|note|style|performance|portability|information): (.*)$`)
+
+// parseDiagnostics extracts all diagnostics from the output of a build (or a
+// static analyzer)
+func parseDiagnostics(out string) []diagnostic {
+	var diags []diagnostic
+	idx := diagRegexp.FindAllStringSubmatchIndex(out, -1)
+	for i, m := range idx {
+		d := diagnostic{File: out[m[2]:m[3]], Severity: out[m[8]:m[9]], Message: out[m[10]:m[11]]}
+		d.Line, _ = strconv.Atoi(out[m[4]:m[5]])
+		if m[6] >= 0 {
+			d.Column, _ = strconv.Atoi(out[m[6]:m[7]])
+		}
+		end := len(out)
+		if i+1 < len(idx) {
+			end = idx[i+1][0]
+		}
+		d.Text = out[m[0]:end]
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// snippetLine is a single line of source code in a snippet
+type snippetLine struct {
+	Num  int
+	Text string
+	Mark bool // Whether this is the line the snippet is about
+}
+
+// snippet returns the lines around line (counting from 1) in src. context is
+// the number of lines to include before and after
+func snippet(src []byte, line, context int) []snippetLine {
+	lines := bytes.Split(src, []byte("\n"))
+	var sn []snippetLine
+	for i := line - context; i <= line+context; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		sn = append(sn, snippetLine{i, string(lines[i-1]), i == line})
+	}
+	return sn
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tcs := []struct {
+		out  string
+		want []diagnostic
+	}{
+		{"", nil},
+		{"make: *** [all] Error 1\n", nil},
+		{
+			"x.cpp: In function 'int main()':\n" +
+				"x.cpp:5:12: error: expected ';' before '}' token\n" +
+				"    5 |   return 0\n" +
+				"      |           ^\n" +
+				"x.h:3: warning: unused variable 'i'\n",
+			[]diagnostic{
+				{
+					File:     "x.cpp",
+					Line:     5,
+					Column:   12,
+					Severity: "error",
+					Message:  "expected ';' before '}' token",
+					Text:     "x.cpp:5:12: error: expected ';' before '}' token\n    5 |   return 0\n      |           ^\n",
+				},
+				{
+					File:     "x.h",
+					Line:     3,
+					Severity: "warning",
+					Message:  "unused variable 'i'",
+					Text:     "x.h:3: warning: unused variable 'i'\n",
+				},
+			},
+		},
+		{
+			"list.cpp:10:3: style: The scope of the variable 'n' can be reduced. [variableScope]\n",
+			[]diagnostic{{
+				File:     "list.cpp",
+				Line:     10,
+				Column:   3,
+				Severity: "style",
+				Message:  "The scope of the variable 'n' can be reduced. [variableScope]",
+				Text:     "list.cpp:10:3: style: The scope of the variable 'n' can be reduced. [variableScope]\n",
+			}},
+		},
+	}
+	for _, tc := range tcs {
+		if got := parseDiagnostics(tc.out); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseDiagnostics(%q) = %+v, want %+v", tc.out, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// maxDiff is the maximum product of the number of lines of the compared texts,
+// for which a full diff is computed. For larger texts, only the first
+// differing line is shown
+const maxDiff = 1 << 20
+
+// diffContext is the number of unchanged lines shown around every change
+const diffContext = 2
+
+// diffOp is a line in a diff
+type diffOp struct {
+	Kind byte // ' ', '-' or '+'
+	Line string
+	A, B int // The line-numbers (0-based) in the old and new text
+}
+
+// diff returns a unified diff of the lines a and b, comparing lines with eq.
+// The hunks have no file-headers, only "@@"-lines
+func diff(a, b []string, eq func(x, y string) bool) string {
+	if len(a)*len(b) > maxDiff {
+		return firstDiff(a, b, eq)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if eq(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && eq(a[i], b[j]):
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	return formatHunks(ops)
+}
+
+// formatHunks writes the changes in ops with diffContext lines of context in
+// unified format
+func formatHunks(ops []diffOp) string {
+	var buf bytes.Buffer
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].Kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk, as long as the changes are close enough to share
+		// context
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].Kind != ' ' {
+				end = k + 1
+			} else if k-end >= 2*diffContext {
+				break
+			}
+		}
+
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		var na, nb int
+		for _, op := range ops[from:to] {
+			if op.Kind != '+' {
+				na++
+			}
+			if op.Kind != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", ops[from].A+1, na, ops[from].B+1, nb)
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&buf, "%c%s\n", op.Kind, op.Line)
+		}
+		start = to
+	}
+	return buf.String()
+}
+
+// firstDiff describes the first line, in which a and b differ
+func firstDiff(a, b []string, eq func(x, y string) bool) string {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		switch {
+		case i == len(a):
+			return fmt.Sprintf("Line %d: expected end of output, got\n+%s\n", i+1, b[i])
+		case i == len(b):
+			return fmt.Sprintf("Line %d: unexpected end of output, expected\n-%s\n", i+1, a[i])
+		case !eq(a[i], b[i]):
+			return fmt.Sprintf("Line %d differs:\n-%s\n+%s\n", i+1, a[i], b[i])
+		}
+	}
+	return ""
+}
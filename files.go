@@ -10,16 +10,17 @@ import (
 	"os"
 	"path"
 	"strings"
+
+	"github.com/Merovius/bor/sandbox"
 )
 
 // File stores a decoded and uncompressed file
 type File struct {
 	b []byte
-	r io.Reader
 }
 
 // UnmarshalJSON reads a gzipped, base64 encoded file from a JSON-string. The
-// Uncompressed form is stored as a slice as well as a reader, for convenience
+// Uncompressed form is stored as a slice
 func (f *File) UnmarshalJSON(b64 []byte) error {
 	raw := bytes.NewReader(b64[1 : len(b64)-1])
 	dec := base64.NewDecoder(base64.StdEncoding, raw)
@@ -35,25 +36,96 @@ func (f *File) UnmarshalJSON(b64 []byte) error {
 	}
 
 	f.b = content
-	f.r = bytes.NewReader(content)
 
 	return nil
 }
 
 // Message is the type of a Request to bor
 type Message struct {
-	Suites []Suite         `json:"suites"`
-	Files  map[string]File `json:"files"`
+	Suites   []Suite         `json:"suites"`
+	Files    map[string]File `json:"files"`
+	Scoring  *Scoring        `json:"scoring,omitempty"`
+	Format   string          `json:"format"`            // The format of the response, see formats
+	Limits   *sandbox.Limits `json:"limits,omitempty"`  // Resource-limits for running the testsuites
+	Timeout  string          `json:"timeout,omitempty"` // Deadline for the whole request, e.g. "30s"
+	Coverage *Coverage       `json:"coverage,omitempty"`
+	Rules    *Rules          `json:"rules,omitempty"`
+
+	// If true, the client closes the connection for writing after sending
+	// the request. Otherwise, this cancels the request
+	HalfClose bool `json:"half_close,omitempty"`
 }
 
 // Suite contains all information about what files to use in a Testsuite
 type Suite struct {
 	Name string   `json:"name"`
 	Link []string `json:"link"`
+
+	// The type of the testsuite. Per default, it is a CppUnit-testsuite,
+	// linked with TAPListener. With "io", the linked program is run once for
+	// every case, with its input on stdin, and its output is compared to the
+	// expected output. With "analyze", nothing is built, but a static analyzer
+	// is run over the files in Link. With "benchmark", the linked program is
+	// run several times to measure its performance
+	Type    string  `json:"type,omitempty"`
+	Cases   []Case  `json:"cases,omitempty"`
+	Compare Compare `json:"compare,omitempty"`
+
+	// For I/O testsuites, the files to link into a checker, that decides
+	// whether an output is correct, instead of comparing it with the
+	// expected output. See (*runner).check
+	Checker []string `json:"checker,omitempty"`
+
+	// For I/O testsuites, the files to link into an interactor, that talks
+	// to the program over its stdin and stdout and decides whether it behaves
+	// correctly. See (*runner).interact
+	Interactor []string `json:"interactor,omitempty"`
+
+	// For analyze testsuites, the analyzer to run (see analyzers), the checks
+	// to enable and the severities of findings, that fail a test (see
+	// defaultFail)
+	Analyzer string   `json:"analyzer,omitempty"`
+	Checks   string   `json:"checks,omitempty"`
+	Fail     []string `json:"fail,omitempty"`
+
+	// For benchmark testsuites
+	Benchmark Benchmark `json:"benchmark,omitempty"`
+
+	// If "test" or "fixture", every test (or fixture) is run in its own
+	// process, with its own timeout
+	Isolate string `json:"isolate,omitempty"`
+
+	// If true, the testsuite is run under valgrind and every leak or invalid
+	// memory access is reported as a failing test
+	Memcheck bool `json:"memcheck,omitempty"`
+
+	// If true, a variant of the testsuite is built with AddressSanitizer and
+	// UndefinedBehaviorSanitizer and run in addition, every test in its own
+	// process. Every error they find is reported as a failing test
+	Sanitize bool `json:"sanitize,omitempty"`
+
+	// If not 0, a testsuite with failing tests is run that many times more.
+	// Tests that pass in some runs and fail in others are marked as flaky.
+	// See (*runner).rerun
+	Reruns int `json:"reruns,omitempty"`
+
+	// If true, the tests of a CppUnit-testsuite are run in a random order,
+	// given by a seed reported in the result. Passing testsuites are rerun as
+	// well, with other seeds. A Seed other than 0 pins the seed of the first
+	// run (and shuffles the tests even without Shuffle), to reproduce a
+	// failure
+	Shuffle bool   `json:"shuffle,omitempty"`
+	Seed    uint32 `json:"seed,omitempty"`
+
+	// Used for grading, see Scoring
+	Points  float64  `json:"points,omitempty"`  // Points for all tests not matched by a weight, evenly distributed
+	Weights []Weight `json:"weights,omitempty"` // Points for single tests
+	Cap     float64  `json:"cap,omitempty"`     // Maximum number of points reachable in this suite. 0 means no cap
 }
 
 // CreateBuildDir writes all files in msg as well as the Makefile needed to
-// build everything into a temporary directory and returns its name.
+// build everything into a temporary directory and returns its name. On error,
+// the directory is removed again.
 func CreateBuildDir(msg Message) (build string, err error) {
 	// We use this to parallelize the IO-operations
 	numgo := 0
@@ -63,6 +135,18 @@ func CreateBuildDir(msg Message) (build string, err error) {
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// The files still being written must be closed, before we can
+		// remove them
+		for ; numgo > 0; numgo-- {
+			<-godone
+		}
+		os.RemoveAll(build)
+		build = ""
+	}()
 
 	// We create mk at the beginning, so we can directly write all informations
 	// to the Makefile, as we create them
@@ -94,12 +178,13 @@ func CreateBuildDir(msg Message) (build string, err error) {
 
 		numgo++
 		// We write the file in a seperate goroutine to parallelize IO as much as possible.
-		// We pass the reader as a parameter, to prevent a race with the closure
+		// We pass the reader as a parameter, to prevent a race with the closure.
+		// It is created here, so the same message can be built more than once
 		go func(r io.Reader) {
 			io.Copy(dst, r)
 			dst.Close()
 			godone <- true
-		}(content.r)
+		}(bytes.NewReader(content.b))
 	}
 
 	// This is where most of the Makefile-magic happens. For every Testsuite we
@@ -107,13 +192,64 @@ func CreateBuildDir(msg Message) (build string, err error) {
 	// building object-files takes depenency tracking and everything off our
 	// hands
 	var testprogs []string
+
+	// For coverage, everything is instrumented
+	if msg.Coverage != nil {
+		fmt.Fprintf(mk, "\nCXXFLAGS += --coverage\nLDFLAGS += --coverage\n")
+	}
+
+	// Object-files for the sanitizer-variant of testsuites. The template can
+	// set SANFLAGS, to use other sanitizers
+	fmt.Fprintf(mk, "\nSANFLAGS ?= %s\n\n", sanitizeFlags)
+	fmt.Fprintf(mk, "%%.san.o: %%.cpp\n\t$(CXX) $(CXXFLAGS) $(SANFLAGS) -c -o $@ $<\n\n")
+
 	for _, suite := range msg.Suites {
 		if len(suite.Link) == 0 {
-			return build, fmt.Errorf("No files to link given in suite", suite.Name)
+			return build, fmt.Errorf("No files to link given in suite %s", suite.Name)
 		}
 		link := strings.Join(suite.Link, ".o ") + ".o"
-		fmt.Fprintf(mk, "%s: TAPListener.o %s\n", suite.Name, link)
-		fmt.Fprintf(mk, "\t$(CXX) $(CXXFLAGS) $(LDFLAGS) -o %s %s TAPListener.o\n\n", suite.Name, link)
+		switch suite.Type {
+		case "":
+			fmt.Fprintf(mk, "%s: TAPListener.o %s\n", suite.Name, link)
+			fmt.Fprintf(mk, "\t$(CXX) $(CXXFLAGS) $(LDFLAGS) -o %s %s TAPListener.o\n\n", suite.Name, link)
+			if suite.Sanitize {
+				san := suite.Name + ".san"
+				link := strings.Join(suite.Link, ".san.o ") + ".san.o"
+				fmt.Fprintf(mk, "%s: TAPListener.san.o %s\n", san, link)
+				fmt.Fprintf(mk, "\t$(CXX) $(CXXFLAGS) $(SANFLAGS) $(LDFLAGS) -o %s %s TAPListener.san.o\n\n", san, link)
+				testprogs = append(testprogs, san)
+			}
+		case "io", "benchmark":
+			// The program is a solution on its own and must not be linked
+			// with the TAPListener, which has a main function
+			fmt.Fprintf(mk, "%s: %s\n", suite.Name, link)
+			fmt.Fprintf(mk, "\t$(CXX) $(CXXFLAGS) $(LDFLAGS) -o %s %s\n\n", suite.Name, link)
+			// The checker, the interactor and the reference solution are
+			// built like the program
+			helpers := map[string][]string{
+				".checker":    suite.Checker,
+				".interactor": suite.Interactor,
+				".reference":  suite.Benchmark.Reference,
+			}
+			for suffix, files := range helpers {
+				if len(files) == 0 {
+					continue
+				}
+				prog := suite.Name + suffix
+				link := strings.Join(files, ".o ") + ".o"
+				fmt.Fprintf(mk, "%s: %s\n", prog, link)
+				fmt.Fprintf(mk, "\t$(CXX) $(CXXFLAGS) $(LDFLAGS) -o %s %s\n\n", prog, link)
+				testprogs = append(testprogs, prog)
+			}
+		case "analyze":
+			if _, ok := analyzers[suite.Analyzer]; !ok {
+				return build, fmt.Errorf("Unknown analyzer %s in suite %s", suite.Analyzer, suite.Name)
+			}
+			// Nothing to build
+			continue
+		default:
+			return build, fmt.Errorf("Unknown type %s of suite %s", suite.Type, suite.Name)
+		}
 
 		// We keep track of all the Testsuites we want to build to put them in
 		// the dependency list of the all-target
@@ -127,6 +263,7 @@ func CreateBuildDir(msg Message) (build string, err error) {
 	}
 	dst, err := os.Create(path.Join(build, "TAPListener.cpp"))
 	if err != nil {
+		src.Close()
 		return
 	}
 
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	seedMu   sync.Mutex
+	seedRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// newSeed returns a random seed for shuffling the tests of a testsuite. It is
+// never 0, which means not to shuffle
+func newSeed() uint32 {
+	seedMu.Lock()
+	defer seedMu.Unlock()
+	for {
+		if s := seedRand.Uint32(); s != 0 {
+			return s
+		}
+	}
+}
+
+// rerun runs the testsuite spec.Reruns more times, if res failed as a whole or
+// has failing tests (or, with spec.Shuffle, in any case, with new seeds). The
+// tests of res, that pass in some runs and fail in others, are marked as
+// flaky. They keep the outcome of the first run. A run failing as a whole
+// counts as a failure of all tests. If it is the first run and a later one
+// does not fail as a whole, res is marked as flaky, but keeps its error
+func (r *runner) rerun(spec Suite, res *cmdResult) {
+	shuffle := spec.Shuffle && spec.Type == ""
+	if res.err == nil && res.suite.Ok && !shuffle {
+		return
+	}
+
+	passed := make(map[string]int)
+	// With shuffling, the seeds of the runs a test failed in, to reproduce it
+	failed := make(map[string][]string)
+	count := func(s *Testsuite, seed uint32) {
+		for _, tl := range s.Tests {
+			if tl.Ok {
+				passed[tl.Description]++
+			} else if seed != 0 {
+				failed[tl.Description] = append(failed[tl.Description], fmt.Sprint(seed))
+			}
+		}
+	}
+	// A run failing as a whole fails all tests of the first run
+	failAll := func(seed uint32) {
+		if seed == 0 || res.suite == nil {
+			return
+		}
+		for _, tl := range res.suite.Tests {
+			failed[tl.Description] = append(failed[tl.Description], fmt.Sprint(seed))
+		}
+	}
+	if res.err == nil {
+		count(res.suite, res.seed)
+	} else {
+		failAll(res.seed)
+	}
+
+	for i := 0; i < spec.Reruns; i++ {
+		seed := res.seed
+		if shuffle {
+			seed = newSeed()
+		}
+		rr := r.run(spec, seed)
+		res.stats.add(rr.stats)
+		if rr.err != nil {
+			failAll(seed)
+			continue
+		}
+		if res.err != nil {
+			res.flaky = true
+		}
+		count(rr.suite, seed)
+	}
+
+	if res.suite == nil {
+		return
+	}
+	runs := spec.Reruns + 1
+	for _, tl := range res.suite.Tests {
+		n := passed[tl.Description]
+		if n == 0 || n == runs {
+			continue
+		}
+		y := parseYAML(tl.Yaml)
+		y["flaky"] = "true"
+		tl.Yaml = formatYAML(y)
+		if tl.Diagnostic != "" && tl.Diagnostic[len(tl.Diagnostic)-1] != '\n' {
+			tl.Diagnostic += "\n"
+		}
+		tl.Diagnostic += fmt.Sprintf("Flaky: passed %d of %d runs\n", n, runs)
+		if seeds := failed[tl.Description]; len(seeds) > 0 {
+			tl.Diagnostic += fmt.Sprintf("Failed with the seeds %s\n", strings.Join(seeds, ", "))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"time"
+)
+
+// htmlDiagnostic is a diagnostic of the build, together with the source code
+// it refers to
+type htmlDiagnostic struct {
+	diagnostic
+	Snippet []snippetLine
+}
+
+// htmlReport contains everything shown in the HTML report
+type htmlReport struct {
+	Suites []suiteWrap
+	Build  []htmlDiagnostic
+}
+
+// htmlTemplate is the template for the HTML report. The report is
+// self-contained, i.e. it needs no external stylesheets or scripts
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"duration": func(d time.Duration) string { return d.String() },
+	"props":    suiteProperties,
+	"yaml":     parseYAML,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bor report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+h2 { margin-bottom: 0.2em; }
+.ok { color: #080; }
+.fail { color: #c00; }
+.stats { color: #666; font-size: 0.9em; }
+pre { background: #f4f4f4; padding: 0.5em; overflow: auto; }
+table.summary td, table.summary th { padding: 0.2em 1em; text-align: left; }
+.snippet .mark { background: #fdd; }
+.snippet .num { color: #999; }
+</style>
+</head>
+<body>
+<h1>Results</h1>
+<table class="summary">
+<tr><th>Suite</th><th>Result</th><th>Tests</th><th>Score</th></tr>
+{{range .Suites}}<tr>
+<td><a href="#suite-{{.Name}}">{{.Name}}</a></td>
+<td>{{if and .Suite.Ok (not .Error)}}<span class="ok">ok</span>{{else}}<span class="fail">failed</span>{{end}}</td>
+<td>{{len .Suite.Tests}}</td>
+<td>{{with .Score}}{{.Points}} / {{.Max}}{{end}}</td>
+</tr>
+{{end}}</table>
+{{if .Build}}
+<h2>Build errors</h2>
+{{range .Build}}<div>
+<p><b>{{.File}}:{{.Line}}{{if .Column}}:{{.Column}}{{end}}</b>: <span class="{{if eq .Severity "note"}}stats{{else}}fail{{end}}">{{.Severity}}</span>: {{.Message}}</p>
+{{if .Snippet}}<pre class="snippet">{{range .Snippet}}<span class="{{if .Mark}}mark{{end}}"><span class="num">{{printf "%4d" .Num}}</span>  {{.Text}}</span>
+{{end}}</pre>{{end}}
+</div>
+{{end}}{{end}}
+{{range .Suites}}
+<h2 id="suite-{{.Name}}">{{.Name}}</h2>
+<div class="stats">user time {{duration .Stats.UserTime}}, system time {{duration .Stats.SystemTime}}{{with .Score}}, {{.Points}} of {{.Max}} points{{end}}{{range props .}}, {{.Name}} {{.Value}}{{end}}</div>
+{{if .Error}}<p class="fail">Error: {{.Error}}</p>{{end}}
+{{if .Output}}<details><summary>Output</summary><pre>{{.Output}}</pre></details>{{end}}
+<ul>
+{{range .Suite.Tests}}<li>{{$y := yaml .Yaml}}
+{{if .Ok}}<span class="ok">ok</span>{{else}}<span class="fail">not ok</span>{{end}} {{.Description}}{{if $y.flaky}} <span class="fail">(flaky)</span>{{end}}
+<span class="stats">{{with $y.file}}{{.}}{{with $y.line}}:{{.}}{{end}} {{end}}{{with $y.duration_us}}{{.}}µs{{end}}</span>
+{{if or $y.expected $y.actual}}<div class="stats">expected {{printf "%q" $y.expected}}, actual {{printf "%q" $y.actual}}</div>{{end}}
+{{if .Diagnostic}}<details{{if not .Ok}} open{{end}}><summary>Diagnostic</summary><pre>{{.Diagnostic}}</pre></details>{{end}}
+</li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// renderHTML writes a human-readable, self-contained HTML report. If the build
+// failed, the diagnostics of the compiler are shown along with the source code
+// they refer to
+func renderHTML(w io.Writer, msg *Message, suites []suiteWrap) error {
+	r := htmlReport{Suites: suites}
+
+	for _, s := range suites {
+		if s.Name != "Building" || s.Suite.Ok {
+			continue
+		}
+		for _, tl := range s.Suite.Tests {
+			for _, d := range parseDiagnostics(tl.Diagnostic) {
+				hd := htmlDiagnostic{diagnostic: d}
+				if f, ok := msg.Files[d.File]; ok {
+					hd.Snippet = snippet(f.b, d.Line, 2)
+				}
+				r.Build = append(r.Build, hd)
+			}
+		}
+	}
+
+	return htmlTemplate.Execute(w, r)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// interact runs the n-th case of an interactive I/O testsuite. The program
+// runs in the test-sandbox and the interactor in the checker-sandbox, each
+// with its own timeout, and the stdout of each is connected to the stdin of
+// the other. The interactor gets the names of the input file and the file
+// with the expected output of the case (/dev/null, if not given) as arguments.
+// It exits with 0, if the program behaved correctly, with 1 if it did not and
+// with anything else, if it fails itself. What it prints on stderr is passed on
+// as the diagnostic of the test
+func (r *runner) interact(spec Suite, n int, tl *tap.Testline) (*tap.Testline, stats) {
+	var st stats
+
+	c := spec.Cases[n]
+	input, output := c.Input, c.Output
+	if input == "" {
+		input = os.DevNull
+	}
+	if output == "" {
+		output = os.DevNull
+	}
+
+	// Connect the program and the interactor
+	pr, iw, err := os.Pipe()
+	if err != nil {
+		tl.Diagnostic = err.Error()
+		return tl, st
+	}
+	ir, pw, err := os.Pipe()
+	if err != nil {
+		pr.Close()
+		iw.Close()
+		tl.Diagnostic = err.Error()
+		return tl, st
+	}
+
+	var perr, ierr bytes.Buffer
+	prog, cancel := r.command(conf.TestTimeout, path.Join(r.builddir, spec.Name))
+	defer cancel()
+	prog.SetStdin(pr)
+	prog.SetStdout(pw)
+	prog.SetStderr(&perr)
+
+	ictx, icancel := context.WithTimeout(r.ctx, conf.TestTimeout)
+	defer icancel()
+	inter := sandbox.CommandContext(ictx, conf.CheckerSandbox, path.Join(r.builddir, spec.Name+".interactor"), input, output)
+	inter.SetDir(r.builddir)
+	inter.SetStdin(ir)
+	inter.SetStdout(iw)
+	inter.SetStderr(&ierr)
+
+	// Some drivers do not pass the pipes to the children, but copy from and
+	// to them (e.g. easysandbox, to strip its magic). So we close our ends of
+	// the pipes of a command only after waiting for it, which also makes the
+	// other side see EOF
+	closeAll := func(fs ...*os.File) {
+		for _, f := range fs {
+			f.Close()
+		}
+	}
+
+	start := time.Now()
+	err = prog.Start()
+	if err == nil {
+		err = inter.Start()
+		if err != nil {
+			prog.Kill()
+			prog.Wait()
+		}
+	}
+	if err != nil {
+		closeAll(pr, pw, ir, iw)
+		tl.Diagnostic = err.Error()
+		return tl, st
+	}
+
+	ich := make(chan error, 1)
+	go func() {
+		err := inter.Wait()
+		closeAll(ir, iw)
+		ich <- err
+	}()
+	perrv := prog.Wait()
+	dur := time.Since(start)
+	closeAll(pr, pw)
+	ierrv := <-ich
+
+	if ev, ok := sandbox.Events(prog); ok && ev.Any() {
+		st.Limits = &ev
+	}
+	if perrv == nil {
+		st.UserTime = prog.ProcessState().UserTime()
+		st.SystemTime = prog.ProcessState().SystemTime()
+	}
+	tl.Yaml = formatYAML(map[string]string{"duration_us": strconv.FormatInt(int64(dur/time.Microsecond), 10)})
+
+	code := inter.ProcessState().ExitCode()
+	switch {
+	case perrv != nil:
+		// The interactor probably complains about the program dying (or
+		// the program about the interactor), so we give both
+		tl.Diagnostic = perrv.Error()
+		if perr.Len() > 0 {
+			tl.Diagnostic += "\n" + perr.String()
+		}
+		if ierr.Len() > 0 {
+			tl.Diagnostic += "\nInteractor:\n" + ierr.String()
+		}
+	case ierrv != nil && code != 1:
+		tl.Diagnostic = fmt.Sprintf("Interactor failed: %v\n%s", ierrv, ierr.Bytes())
+	case code == 1:
+		tl.Diagnostic = "Wrong answer:\n" + ierr.String()
+	default:
+		tl.Ok = true
+		tl.Diagnostic = ierr.String()
+	}
+	return tl, st
+}
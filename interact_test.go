@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Merovius/go-tap"
+)
+
+// magic is printed by the EasySandbox library on stdout and stderr. The test
+// program prints it itself, so the test does not need the library
+const magic = "printf '<<entering SECCOMP mode>>\\n'; printf '<<entering SECCOMP mode>>\\n' >&2\n"
+
+func TestInteract(t *testing.T) {
+	if _, err := os.Stat("/usr/lib/EasySandbox/EasySandbox.so"); err == nil {
+		t.Skip("EasySandbox is installed and would kill the shell")
+	}
+
+	tcs := []struct {
+		sandbox string
+		prog    string
+	}{
+		{"plain", "#!/bin/sh\nread x; echo \"$x\"\n"},
+		{"easysandbox", "#!/bin/sh\n" + magic + "read x; echo \"$x\"\n"},
+	}
+	inter := "#!/bin/sh\necho hello; read y; test \"$y\" = hello\n"
+
+	defer func(c Conf) { conf = c }(conf)
+	conf.TestTimeout = 5 * time.Second
+	conf.CheckerSandbox = "plain"
+
+	for _, tc := range tcs {
+		dir, err := ioutil.TempDir("", "bor-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "echo"), []byte(tc.prog), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "echo.interactor"), []byte(inter), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		conf.TestSandbox = tc.sandbox
+		r := &runner{ctx: context.Background(), builddir: dir, msg: &Message{}}
+		spec := Suite{Name: "echo", Type: "io", Cases: []Case{{}}}
+		start := time.Now()
+		tl, _ := r.interact(spec, 0, &tap.Testline{Num: 1})
+		if !tl.Ok {
+			t.Errorf("%s: interact failed: %s", tc.sandbox, tl.Diagnostic)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: interact took %v", tc.sandbox, d)
+		}
+	}
+}
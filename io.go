@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// maxYAMLOutput is the maximum length of the expected and actual output, that
+// is passed on in the result of an I/O test
+const maxYAMLOutput = 4096
+
+// Case is a single case of an I/O testsuite. Input and Output are names of
+// files of the request
+type Case struct {
+	Name   string `json:"name,omitempty"`  // Defaults to Input
+	Input  string `json:"input,omitempty"` // Given on stdin. If empty, stdin is empty
+	Output string `json:"output"`          // The expected output
+}
+
+// Compare describes how the output of an I/O test is compared to the expected
+// output. Per default, whitespace at the end of lines and empty lines at the end
+// of the output are ignored
+type Compare struct {
+	IgnoreWhitespace bool    `json:"ignore_whitespace,omitempty"` // Only compare the whitespace-separated tokens
+	IgnoreCase       bool    `json:"ignore_case,omitempty"`       // Compare case-insensitively
+	Tolerance        float64 `json:"tolerance,omitempty"`         // Maximum absolute or relative error of numbers
+}
+
+// lines splits an output into the lines to compare. With IgnoreWhitespace,
+// every token is a line of its own
+func (c Compare) lines(s string) []string {
+	if c.IgnoreWhitespace {
+		return strings.Fields(s)
+	}
+	ls := strings.Split(s, "\n")
+	for i := range ls {
+		ls[i] = strings.TrimRight(ls[i], " \t\r")
+	}
+	for len(ls) > 0 && ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
+
+// equalLine reports whether a line of the actual output matches the expected
+// one. If a tolerance is given, the tokens of the lines are compared separately
+func (c Compare) equalLine(exp, act string) bool {
+	if c.Tolerance == 0 {
+		return exp == act || (c.IgnoreCase && strings.EqualFold(exp, act))
+	}
+	ef, af := strings.Fields(exp), strings.Fields(act)
+	if len(ef) != len(af) {
+		return false
+	}
+	for i := range ef {
+		if !c.equalToken(ef[i], af[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalToken reports whether a single token of the actual output matches the
+// expected one
+func (c Compare) equalToken(exp, act string) bool {
+	if exp == act || (c.IgnoreCase && strings.EqualFold(exp, act)) {
+		return true
+	}
+	e, err := strconv.ParseFloat(exp, 64)
+	if err != nil {
+		return false
+	}
+	a, err := strconv.ParseFloat(act, 64)
+	if err != nil {
+		return false
+	}
+	d := math.Abs(e - a)
+	return d <= c.Tolerance || d <= c.Tolerance*math.Abs(e)
+}
+
+// runIO runs the program of an I/O testsuite once for every case and compares
+// its output with the expected one
+func (r *runner) runIO(spec Suite) cmdResult {
+	var res cmdResult
+
+	suite := &Testsuite{Ok: true}
+	for i := range spec.Cases {
+		tl, st := r.runCase(spec, i)
+		tl.Num = uint(i + 1)
+		res.stats.add(st)
+		if !tl.Ok {
+			suite.Ok = false
+		}
+		suite.Tests = append(suite.Tests, tl)
+	}
+
+	res.suite = suite
+	return res
+}
+
+// runCase runs the n-th case of an I/O testsuite
+func (r *runner) runCase(spec Suite, n int) (*tap.Testline, stats) {
+	var st stats
+
+	c := spec.Cases[n]
+	tl := &tap.Testline{Description: c.Name}
+	if tl.Description == "" {
+		tl.Description = c.Input
+	}
+
+	if len(spec.Interactor) > 0 {
+		return r.interact(spec, n, tl)
+	}
+
+	expected, err := ioutil.ReadFile(path.Join(r.builddir, c.Output))
+	if err != nil {
+		tl.Diagnostic = fmt.Sprintf("Could not read expected output: %v", err)
+		return tl, st
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd, cancel := r.command(conf.TestTimeout, path.Join(r.builddir, spec.Name))
+	defer cancel()
+	cmd.SetStdout(&stdout)
+	cmd.SetStderr(&stderr)
+
+	if c.Input != "" {
+		in, err := os.Open(path.Join(r.builddir, c.Input))
+		if err != nil {
+			tl.Diagnostic = fmt.Sprintf("Could not read input: %v", err)
+			return tl, st
+		}
+		defer in.Close()
+
+		w, err := cmd.StdinPipe()
+		if err != nil {
+			tl.Diagnostic = err.Error()
+			return tl, st
+		}
+		// The program does not need to read all of its input, so we ignore
+		// errors while writing it
+		go func() {
+			io.Copy(w, in)
+			w.Close()
+		}()
+	}
+
+	start := time.Now()
+	err = cmd.Run()
+	dur := time.Since(start)
+	if ev, ok := sandbox.Events(cmd); ok && ev.Any() {
+		st.Limits = &ev
+	}
+
+	if err != nil {
+		tl.Diagnostic = err.Error()
+		if stderr.Len() > 0 {
+			tl.Diagnostic += "\n" + stderr.String()
+		}
+		return tl, st
+	}
+	st.UserTime = cmd.ProcessState().UserTime()
+	st.SystemTime = cmd.ProcessState().SystemTime()
+
+	y := map[string]string{"duration_us": strconv.FormatInt(int64(dur/time.Microsecond), 10)}
+	if len(spec.Checker) > 0 {
+		tl.Ok, tl.Diagnostic = r.check(spec, n, stdout.Bytes())
+	} else {
+		el, al := spec.Compare.lines(string(expected)), spec.Compare.lines(stdout.String())
+		tl.Ok = len(el) == len(al)
+		for i := 0; tl.Ok && i < len(el); i++ {
+			tl.Ok = spec.Compare.equalLine(el[i], al[i])
+		}
+		if !tl.Ok {
+			tl.Diagnostic = "Wrong output:\n" + diff(el, al, spec.Compare.equalLine)
+		}
+	}
+	if !tl.Ok {
+		y["expected"] = truncate(string(expected), maxYAMLOutput)
+		y["actual"] = truncate(stdout.String(), maxYAMLOutput)
+	}
+	tl.Yaml = formatYAML(y)
+	return tl, st
+}
+
+// check runs the checker of an I/O testsuite in the checker-sandbox, to decide
+// whether actual is a correct output for the n-th case. The checker gets the
+// names of the input file (/dev/null, if there is none), the file with the
+// expected output and a file with the actual output. It exits with 0, if the
+// output is correct, with 1 if it is wrong and with anything else, if it
+// fails itself. What it prints is passed on as the diagnostic of the test
+func (r *runner) check(spec Suite, n int, actual []byte) (ok bool, msg string) {
+	c := spec.Cases[n]
+
+	input := c.Input
+	if input == "" {
+		input = os.DevNull
+	}
+	name := fmt.Sprintf("%s.%d.actual", spec.Name, n)
+	if err := ioutil.WriteFile(path.Join(r.builddir, name), actual, 0644); err != nil {
+		return false, fmt.Sprintf("Could not write output: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, conf.TestTimeout)
+	defer cancel()
+	cmd := sandbox.CommandContext(ctx, conf.CheckerSandbox, path.Join(r.builddir, spec.Name+".checker"), input, c.Output, name)
+	cmd.SetDir(r.builddir)
+	out, err := sandbox.CombinedOutput(cmd)
+	if err == nil {
+		return true, string(out)
+	}
+	if ps := cmd.ProcessState(); ps != nil && ps.ExitCode() == 1 {
+		return false, "Wrong output:\n" + string(out)
+	}
+	return false, fmt.Sprintf("Checker failed: %v\n%s", err, out)
+}
+
+// truncate shortens s to at most n bytes, marking if something was cut off
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "\n[...]"
+}
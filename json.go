@@ -1,8 +1,11 @@
 package main
 
 import (
-	"time"
 	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Merovius/bor/sandbox"
 	"github.com/Merovius/go-tap"
 )
 
@@ -11,8 +14,21 @@ type Testsuite tap.Testsuite
 
 // stats contains all available information about the process-execution
 type stats struct {
-	SystemTime time.Duration `json:"system_time"`
-	UserTime   time.Duration `json:"user_time"`
+	SystemTime time.Duration        `json:"system_time"`
+	UserTime   time.Duration        `json:"user_time"`
+	Limits     *sandbox.LimitEvents `json:"limits,omitempty"` // Only given, if any limit was hit
+}
+
+// add adds the stats of another process to s
+func (s *stats) add(o stats) {
+	s.SystemTime += o.SystemTime
+	s.UserTime += o.UserTime
+	if o.Limits != nil {
+		if s.Limits == nil {
+			s.Limits = &sandbox.LimitEvents{}
+		}
+		s.Limits.Add(*o.Limits)
+	}
 }
 
 // suitWrap wraps the suits to give all the output, bor gives
@@ -22,9 +38,19 @@ type suiteWrap struct {
 	Stats  stats     `json:"stats"`
 	Error  string    `json:"error,omitempty"`
 	Output string    `json:"output,omitempty"`
+	Score  *score    `json:"score,omitempty"`
+	Seed   uint32    `json:"seed,omitempty"`  // The seed the tests were shuffled with, 0 if not shuffled
+	Flaky  bool      `json:"flaky,omitempty"` // The suite failed as a whole, but not in all reruns
+
+	// Only in the suite "Coverage"
+	Coverage []fileCoverage `json:"coverage,omitempty"`
 }
 
-// MarshalJSON marshalls a Testsuite into the format used by bor
+// MarshalJSON marshalls a Testsuite into the format used by bor. Structured
+// information about failures, given by TAPListener.cpp in the YAML-block of a
+// test, is added as the fields expected, actual, file and line. The duration of
+// a test is added as the field duration, tests marked as flaky get the field
+// flaky
 func (t *Testsuite) MarshalJSON() ([]byte, error) {
 	m := make(map[string]interface{})
 	m["ok"] = t.Ok
@@ -35,6 +61,22 @@ func (t *Testsuite) MarshalJSON() ([]byte, error) {
 		tm["ok"] = tl.Ok
 		tm["description"] = tl.Description
 		tm["diagnostic"] = tl.Diagnostic
+
+		y := parseYAML(tl.Yaml)
+		for _, k := range []string{"expected", "actual", "file"} {
+			if v, ok := y[k]; ok {
+				tm[k] = v
+			}
+		}
+		if l, err := strconv.Atoi(y["line"]); err == nil {
+			tm["line"] = l
+		}
+		if us, err := strconv.ParseInt(y["duration_us"], 10, 64); err == nil {
+			tm["duration"] = time.Duration(us) * time.Microsecond
+		}
+		if y["flaky"] == "true" {
+			tm["flaky"] = true
+		}
 		tests = append(tests, tm)
 	}
 	m["tests"] = tests
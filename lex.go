@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// tokenKind is the kind of a token of C++ source
+type tokenKind int
+
+const (
+	tokIdent     tokenKind = iota // An identifier or keyword
+	tokNumber                     // A numeric literal
+	tokString                     // A string or character literal
+	tokPunct                      // An operator or punctuation
+	tokInclude                    // An #include directive, Text is the header including the delimiters, e.g. "<list>"
+	tokDirective                  // Any other preprocessor directive, Text is the complete directive
+)
+
+// token is a token of C++ source
+type token struct {
+	Kind   tokenKind
+	Text   string
+	Line   int // Counting from 1
+	Offset int // Of the first byte in the source
+}
+
+// puncts are the operators and punctuation consisting of more than one
+// character, longest first, so the first match is the right one
+var puncts = []string{
+	"<<=", ">>=", "->*", "...",
+	"::", "->", "++", "--", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", ".*",
+}
+
+// lex splits C++ source into tokens. Comments and whitespace are skipped. This
+// is not a complete lexer for C++ (e.g. digraphs or user-defined literals are
+// not understood), but good enough to find uses of identifiers and operators
+func lex(src []byte) []token {
+	var toks []token
+	line := 1
+	bol := true // Whether only whitespace came before on this line
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		start, sline := i, line
+
+		switch {
+		case c == '\n':
+			line++
+			bol = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			line++
+			i += 2
+			continue
+		case bytes.HasPrefix(src[i:], []byte("//")):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case bytes.HasPrefix(src[i:], []byte("/*")):
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += i + 4
+			}
+			line += bytes.Count(src[i:end], []byte("\n"))
+			i = end
+			continue
+		case c == '#' && bol:
+			// A directive continues up to the next unescaped newline
+			for i < len(src) && src[i] != '\n' {
+				if src[i] == '\\' && i+1 < len(src) && src[i+1] == '\n' {
+					line++
+					i++
+				}
+				i++
+			}
+			toks = append(toks, directive(string(src[start:i]), sline, start))
+			continue
+		}
+		bol = false
+
+		switch {
+		case isIdentStart(c):
+			for i < len(src) && isIdentChar(src[i]) {
+				i++
+			}
+			// Prefixes of string literals, e.g. u8"…" or R"(…)"
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') && isLiteralPrefix(string(src[start:i])) {
+				raw := strings.HasSuffix(string(src[start:i]), "R")
+				i = skipLiteral(src, i, raw)
+				line += bytes.Count(src[start:i], []byte("\n"))
+				toks = append(toks, token{tokString, string(src[start:i]), sline, start})
+				continue
+			}
+			toks = append(toks, token{tokIdent, string(src[start:i]), sline, start})
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			// This also eats things like 1e+5 and 0x1p-3, but does not need
+			// to be exact
+			for i < len(src) {
+				d := src[i]
+				if isIdentChar(d) || d == '.' || d == '\'' {
+					i++
+				} else if (d == '+' || d == '-') && strings.ContainsRune("eEpP", rune(src[i-1])) {
+					i++
+				} else {
+					break
+				}
+			}
+			toks = append(toks, token{tokNumber, string(src[start:i]), sline, start})
+		case c == '"' || c == '\'':
+			i = skipLiteral(src, i, false)
+			line += bytes.Count(src[start:i], []byte("\n"))
+			toks = append(toks, token{tokString, string(src[start:i]), sline, start})
+		default:
+			p := string(c)
+			for _, q := range puncts {
+				if bytes.HasPrefix(src[i:], []byte(q)) {
+					p = q
+					break
+				}
+			}
+			i += len(p)
+			toks = append(toks, token{tokPunct, p, sline, start})
+		}
+	}
+	return toks
+}
+
+// directive creates the token for the preprocessor directive d
+func directive(d string, line, offset int) token {
+	// "# include <list>" and "#include<list>" are both valid
+	s := strings.TrimSpace(strings.TrimSpace(d)[1:])
+	if !strings.HasPrefix(s, "include") {
+		return token{tokDirective, d, line, offset}
+	}
+	s = strings.TrimSpace(s[len("include"):])
+	if s == "" {
+		return token{tokDirective, d, line, offset}
+	}
+	end := -1
+	switch s[0] {
+	case '<':
+		end = strings.IndexByte(s, '>')
+	case '"':
+		end = strings.IndexByte(s[1:], '"') + 1
+	}
+	if end <= 0 {
+		return token{tokDirective, d, line, offset}
+	}
+	return token{tokInclude, s[:end+1], line, offset}
+}
+
+// skipLiteral returns the index after the string or character literal
+// starting at src[i]. With raw, it is a raw string literal
+func skipLiteral(src []byte, i int, raw bool) int {
+	q := src[i]
+	if raw && q == '"' {
+		// R"delim(…)delim"
+		open := bytes.IndexByte(src[i:], '(')
+		if open < 0 {
+			return len(src)
+		}
+		end := []byte(")" + string(src[i+1:i+open]) + `"`)
+		n := bytes.Index(src[i+open:], end)
+		if n < 0 {
+			return len(src)
+		}
+		return i + open + n + len(end)
+	}
+	for i++; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case q:
+			return i + 1
+		case '\n':
+			// Unterminated literal
+			return i
+		}
+	}
+	return len(src)
+}
+
+// isLiteralPrefix reports whether s is a valid prefix of a string literal
+func isLiteralPrefix(s string) bool {
+	switch s {
+	case "L", "u", "U", "u8", "R", "LR", "uR", "UR", "u8R":
+		return true
+	}
+	return false
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
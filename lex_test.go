@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tcs := []struct {
+		name string
+		src  string
+		want []token // Offsets are not compared
+	}{
+		{
+			name: "comments and literals",
+			src:  "a /* b\n */ 'c' // d\n\"e\\\"\" f",
+			want: []token{
+				{tokIdent, "a", 1, 0},
+				{tokString, "'c'", 2, 0},
+				{tokString, `"e\""`, 3, 0},
+				{tokIdent, "f", 3, 0},
+			},
+		},
+		{
+			name: "raw string",
+			src:  "s = R\"x(a)\" system(\"\n)x\"; u8\"y\" z",
+			want: []token{
+				{tokIdent, "s", 1, 0},
+				{tokPunct, "=", 1, 0},
+				{tokString, "R\"x(a)\" system(\"\n)x\"", 1, 0},
+				{tokPunct, ";", 2, 0},
+				{tokString, `u8"y"`, 2, 0},
+				{tokIdent, "z", 2, 0},
+			},
+		},
+		{
+			name: "line continuation",
+			src:  "int a \\\n= 1.5e+3;\nb->c",
+			want: []token{
+				{tokIdent, "int", 1, 0},
+				{tokIdent, "a", 1, 0},
+				{tokPunct, "=", 2, 0},
+				{tokNumber, "1.5e+3", 2, 0},
+				{tokPunct, ";", 2, 0},
+				{tokIdent, "b", 3, 0},
+				{tokPunct, "->", 3, 0},
+				{tokIdent, "c", 3, 0},
+			},
+		},
+		{
+			name: "directives",
+			src:  "# include <list>\n#include\"a.h\"\n#define X \\\n\tsystem()\nx # y",
+			want: []token{
+				{tokInclude, "<list>", 1, 0},
+				{tokInclude, `"a.h"`, 2, 0},
+				{tokDirective, "#define X \\\n\tsystem()", 3, 0},
+				{tokIdent, "x", 5, 0},
+				{tokPunct, "#", 5, 0},
+				{tokIdent, "y", 5, 0},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		got := lex([]byte(tc.src))
+		for i := range got {
+			got[i].Offset = 0
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: lex(%q) = %v, want %v", tc.name, tc.src, got, tc.want)
+		}
+	}
+}
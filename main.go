@@ -1,21 +1,28 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
-	"path"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Merovius/bor/sandbox"
 	_ "github.com/Merovius/bor/sandbox/easysandbox"
+	_ "github.com/Merovius/bor/sandbox/landlock"
+	_ "github.com/Merovius/bor/sandbox/namespaces"
 	_ "github.com/Merovius/bor/sandbox/plain"
+	_ "github.com/Merovius/bor/sandbox/rlimit"
+	_ "github.com/Merovius/bor/sandbox/seccomp"
 	"github.com/Merovius/go-tap"
 )
 
@@ -27,6 +34,8 @@ type cmdResult struct {
 	stats  stats
 	err    error
 	suite  *Testsuite
+	seed   uint32
+	flaky  bool // err is set, but a rerun succeeded
 }
 
 // Using elog instead of just log makes it easy to employ syslog-capabilities
@@ -35,6 +44,10 @@ var (
 	elog *log.Logger
 )
 
+// keepAlive is the interval of the TCP keepalive probes, detecting clients that
+// went away
+const keepAlive = 15 * time.Second
+
 // Per default we just log to stderr
 func init() {
 	elog = log.New(os.Stderr, "", log.LstdFlags)
@@ -42,8 +55,9 @@ func init() {
 
 // HandleConnection reads a request from a connection, builds the testsuites
 // and executes them, aggregating the results and passing them back to the
-// connection
-func HandleConnection(conn *net.TCPConn) {
+// connection. Building and testing is canceled when ctx is done, the client
+// closes the connection or the deadline of the request expires
+func HandleConnection(ctx context.Context, conn *net.TCPConn) {
 	// Usually the operating system handles connection-lingering, but there was
 	// a problem once, we attributed to lack of this, so we put it in and saw
 	// no need to take it out again
@@ -61,11 +75,83 @@ func HandleConnection(conn *net.TCPConn) {
 		return
 	}
 
-	// Create the build-dir and write everything to it
-	builddir, err := CreateBuildDir(msg)
+	// Find out, how to render the response. Unknown formats are an error of
+	// the client, which we report in the default format
+	render, ok := formats[msg.Format]
+	if !ok {
+		elog.Println("Unknown format:", msg.Format)
+		suites := []suiteWrap{failedBuild(fmt.Errorf("Unknown format: %q", msg.Format))}
+		if err = renderJSON(conn, &msg, suites); err != nil {
+			elog.Println("Could not encode: ", err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The client may restrict the total time spent on its request
+	if msg.Timeout != "" {
+		d, err := time.ParseDuration(msg.Timeout)
+		if err != nil {
+			elog.Println("Invalid timeout:", err)
+			suites := []suiteWrap{failedBuild(fmt.Errorf("Invalid timeout: %v", err))}
+			if err = render(conn, &msg, suites); err != nil {
+				elog.Println("Could not encode: ", err)
+			}
+			return
+		}
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	// The client does not send anything after the request (except maybe some
+	// whitespace), so the read failing means, that it closed the connection
+	// and is no longer interested in the result. Closing the connection for
+	// writing looks the same, so clients doing that have to say so. They are
+	// still noticed going away, once the keepalive probes are rejected
+	conn.SetKeepAlive(true)
+	conn.SetKeepAlivePeriod(keepAlive)
+	go func() {
+		b := make([]byte, 512)
+		for {
+			_, err := conn.Read(b)
+			if err == io.EOF && msg.HalfClose {
+				return
+			}
+			if err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	suites, err := runRequest(ctx, &msg)
 	if err != nil {
 		elog.Println("Could not create buildpath:", err)
-		return
+		suites = []suiteWrap{failedBuild(fmt.Errorf("Could not create buildpath: %v", err))}
+	}
+	if err = render(conn, &msg, suites); err != nil {
+		elog.Println("Could not encode: ", err)
+	}
+}
+
+// failedBuild returns a failed build-suite, with err as the diagnostic of its
+// test. It is used to report errors in the request (e.g. an invalid timeout or
+// suite), before anything is built
+func failedBuild(err error) suiteWrap {
+	test := &tap.Testline{Num: 1, Description: "Building", Diagnostic: err.Error()}
+	return suiteWrap{Name: "Building", Suite: Testsuite{Ok: false, Tests: []*tap.Testline{test}}}
+}
+
+// runRequest builds the testsuites of msg in a new build-dir and executes them,
+// aggregating the results (including the build and, if requested, the score).
+// An error is only returned, if the build-dir could not be created
+func runRequest(ctx context.Context, msg *Message) (suites []suiteWrap, err error) {
+	// Create the build-dir and write everything to it
+	builddir, err := CreateBuildDir(*msg)
+	if err != nil {
+		return nil, err
 	}
 	defer os.RemoveAll(builddir)
 
@@ -74,25 +160,36 @@ func HandleConnection(conn *net.TCPConn) {
 	test := &tap.Testline{Num: 1, Description: "Building"}
 	buildsuite.Suite.Tests[0] = test
 
-	// We collect all suites and send them off after returning, no matter when
-	// we return. This makes it easier to handle error cases
-	var suites []suiteWrap
+	// We collect all suites and return them, no matter when we return. This
+	// makes it easier to handle error cases
+
+	// The output of the build is needed for grading
+	var buildout []byte
 
 	defer func() {
-		enc := json.NewEncoder(conn)
-		if err = enc.Encode(suites); err != nil {
-			elog.Println("Could not encode: ", err)
+		if msg.Scoring != nil {
+			suites = msg.Scoring.Apply(suites, msg.Suites, buildout)
 		}
 	}()
 
 	// Run make in the make sandbox. Use -j to parallelize the build
-	cmd := sandbox.Command(conf.MakeSandbox, "make", "-j", fmt.Sprintf("%d", runtime.NumCPU()), "all")
+	mctx, mcancel := context.WithTimeout(ctx, conf.MakeTimeout)
+	cmd := sandbox.CommandContext(mctx, conf.MakeSandbox, "make", "-j", fmt.Sprintf("%d", runtime.NumCPU()), "all")
 	cmd.SetDir(builddir)
-	out, err := sandbox.TimeoutCombinedOutput(cmd, 5*time.Second)
-	buildsuite.Stats.SystemTime = cmd.ProcessState().SystemTime()
-	buildsuite.Stats.UserTime = cmd.ProcessState().UserTime()
+	out, err := sandbox.CombinedOutput(cmd)
+	mcancel()
+	buildout = out
+	// make does not even start, if the request is canceled before
+	ps := cmd.ProcessState()
+	if ps != nil {
+		buildsuite.Stats.SystemTime = ps.SystemTime()
+		buildsuite.Stats.UserTime = ps.UserTime()
+	}
+	if ev, ok := sandbox.Events(cmd); ok && ev.Any() {
+		buildsuite.Stats.Limits = &ev
+	}
 
-	if !cmd.ProcessState().Success() {
+	if ps == nil || !ps.Success() {
 		// Build did not succed, give some context and return, writing the
 		// build-suite to the connection
 		test.Ok = false
@@ -104,25 +201,17 @@ func HandleConnection(conn *net.TCPConn) {
 			test.Diagnostic += err.Error()
 		}
 		suites = append(suites, buildsuite)
-		return
+		return suites, nil
 	}
 	test.Ok = true
 	buildsuite.Suite.Ok = true
 
 	suites = append(suites, buildsuite)
 
-	// We know what the Testsuites are simply by listing all executable files
-	// in the builddir
-	d, err := os.Open(builddir)
-	if err != nil {
-		elog.Println("Could not open build-dir: ", err)
-		return
-	}
+	r := &runner{ctx: ctx, builddir: builddir, msg: msg}
 
-	fi, err := d.Readdir(-1)
-	if err != nil {
-		elog.Println("Could not read build-dir: ", err)
-		return
+	if msg.Rules != nil {
+		suites = append(suites, r.runRules(msg.Rules))
 	}
 
 	ch := make(chan cmdResult)
@@ -135,81 +224,78 @@ func HandleConnection(conn *net.TCPConn) {
 	// executed by it
 	n := len(suites)
 
-	for _, fi := range fi {
-		mode := fi.Mode()
-		// Skip non-regular and non-executable files
-		if mode&os.ModeType != 0 || mode&1 != 1 {
-			continue
-		}
+	// Benchmarks are run after all other testsuites, one at a time, so that
+	// their times are not measured under contention. We remember their index
+	// in msg.Suites and in suites
+	var benchmarks [][2]int
 
+	for j, spec := range msg.Suites {
 		// Create a basic suite, already add it to the list of run buildsuites,
 		// to preserve ordering
-		wrap := suiteWrap{Name: fi.Name()}
+		wrap := suiteWrap{Name: spec.Name}
 		suites = append(suites, wrap)
 
-		// Run the testsuite in the background. We have to pass name and the
-		// index as parameters, to prevent races with fi
-		go func(name string, i int) {
-			res := cmdResult{n: i}
-			cmd := sandbox.Command(conf.TestSandbox, path.Join(builddir, name))
-			cmd.SetDir(builddir)
-			out, err := sandbox.TimeoutCombinedOutput(cmd, time.Second)
-			if err != nil {
-				elog.Println("Could not run testsuite: ", err)
-				res.err = err
-				ch <- res
-				return
-			}
-			res.stats.UserTime = cmd.ProcessState().UserTime()
-			res.stats.SystemTime = cmd.ProcessState().SystemTime()
-
-			// Parse the TAP
-			r := bytes.NewReader(out)
-			parser, err := tap.NewParser(r)
-			if err != nil {
-				res.err = err
-				ch <- res
-				return
-			}
-
-			suite, err := parser.Suite()
-			if err != nil {
-				res.err = err
-				ch <- res
-				return
-			}
-
-			res.suite = (*Testsuite)(suite)
+		if spec.Type == "benchmark" {
+			benchmarks = append(benchmarks, [2]int{j, n})
+			n++
+			continue
+		}
 
+		// Run the testsuite in the background. We have to pass spec and the
+		// index as parameters, to prevent races with the loop variables
+		go func(spec Suite, i int) {
+			res := r.runSuite(spec)
+			res.n = i
 			ch <- res
-			return
-		}(fi.Name(), n)
+		}(spec, n)
 
 		n++
 		numgo++
 	}
 
-	// Collect the results
-	for ; numgo > 0; numgo-- {
-		res := <-ch
+	// collect stores a result in its suite
+	collect := func(res cmdResult) {
 		suite := &suites[res.n]
+		suite.Seed = res.seed
+		suite.Flaky = res.flaky
 
 		if res.err != nil {
 			suite.Error = res.err.Error()
 			suite.Stats = res.stats
 			suite.Output = string(res.output)
-			continue
+			return
 		}
 
 		suite.Stats = res.stats
 		suite.Suite = *res.suite
 	}
 
-	return
+	// Collect the results
+	for ; numgo > 0; numgo-- {
+		collect(<-ch)
+	}
+
+	for _, b := range benchmarks {
+		res := r.runSuite(msg.Suites[b[0]])
+		res.n = b[1]
+		collect(res)
+	}
+
+	// The coverage-data is written by all testsuites, so we can only collect
+	// it after all of them finished
+	if msg.Coverage != nil {
+		suites = append(suites, r.runCoverage(msg.Coverage))
+	}
+
+	return suites, nil
 }
 
 func main() {
 	var err error
+
+	// Some sandboxes re-execute bor to set up a command
+	sandbox.RunHelper()
+
 	// Get the -config flag, if existent
 	flag.Parse()
 
@@ -218,6 +304,13 @@ func main() {
 		elog.Fatal(err)
 	}
 
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(validate(flag.Args()[1:]))
+	case "mutate":
+		os.Exit(mutate(flag.Args()[1:]))
+	}
+
 	// Listen on the specified interface/port
 	addr, err := net.ResolveTCPAddr("tcp", conf.TCPListen)
 	if err != nil {
@@ -227,16 +320,38 @@ func main() {
 	if err != nil {
 		elog.Fatal(err)
 	}
-	defer l.Close()
 	log.Println("Listening on", conf.TCPListen)
 
+	// On SIGINT or SIGTERM we stop accepting connections and cancel all
+	// running requests
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Println("Got", s, "shutting down")
+		cancel()
+		l.Close()
+	}()
+
 	// Handle connections in the background
+	var wg sync.WaitGroup
 	for {
 		conn, err := l.AcceptTCP()
 		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
 			elog.Println(err)
 			continue
 		}
-		go HandleConnection(conn)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			HandleConnection(ctx, conn)
+		}()
 	}
+
+	// Wait for the canceled requests to clean up their build-dirs
+	wg.Wait()
 }
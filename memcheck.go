@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/Merovius/go-tap"
+)
+
+// memcheckArgs returns the arguments to valgrind, to run prog with memcheck,
+// writing the found errors as XML to xmlfile. The text output of valgrind is
+// discarded, so it does not get mixed with the TAP of the testsuite
+func memcheckArgs(xmlfile, prog string) []string {
+	return []string{
+		"--tool=memcheck",
+		"--leak-check=full",
+		"--show-leak-kinds=definite,indirect",
+		"--child-silent-after-fork=yes",
+		"--xml=yes",
+		"--xml-file=" + xmlfile,
+		"--log-file=/dev/null",
+		prog,
+	}
+}
+
+// memcheckOutput is the XML written by valgrind. Only the parts we need are
+// parsed
+type memcheckOutput struct {
+	Errors []memcheckError `xml:"error"`
+}
+
+// memcheckError is a single error found by memcheck
+type memcheckError struct {
+	Kind    string          `xml:"kind"`
+	What    string          `xml:"what"`       // For invalid accesses
+	XWhat   string          `xml:"xwhat>text"` // For leaks
+	AuxWhat []string        `xml:"auxwhat"`    // Describe the stacks after the first
+	Stacks  []memcheckStack `xml:"stack"`
+}
+
+// memcheckStack is a stack trace in the output of memcheck
+type memcheckStack struct {
+	Frames []memcheckFrame `xml:"frame"`
+}
+
+// memcheckFrame is a single frame of a stack trace
+type memcheckFrame struct {
+	IP   string `xml:"ip"`
+	Obj  string `xml:"obj"`
+	Fn   string `xml:"fn"`
+	Dir  string `xml:"dir"`
+	File string `xml:"file"`
+	Line int    `xml:"line"`
+}
+
+// String formats the frame like valgrind does in its text output
+func (f memcheckFrame) String() string {
+	fn := f.Fn
+	if fn == "" {
+		fn = "???"
+	}
+	switch {
+	case f.File != "":
+		return fmt.Sprintf("%s: %s (%s:%d)", f.IP, fn, f.File, f.Line)
+	case f.Obj != "":
+		return fmt.Sprintf("%s: %s (in %s)", f.IP, fn, f.Obj)
+	}
+	return fmt.Sprintf("%s: %s", f.IP, fn)
+}
+
+// parseMemcheck reads the errors from the XML written by valgrind. Leaks that
+// are still reachable or only possibly lost are ignored
+func parseMemcheck(xmlfile string) ([]memcheckError, error) {
+	f, err := os.Open(xmlfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out memcheckOutput
+	if err = xml.NewDecoder(f).Decode(&out); err != nil {
+		return nil, fmt.Errorf("Could not parse output of valgrind: %v", err)
+	}
+
+	var errs []memcheckError
+	for _, e := range out.Errors {
+		switch e.Kind {
+		case "Leak_StillReachable", "Leak_PossiblyLost":
+			continue
+		}
+		errs = append(errs, e)
+	}
+	return errs, nil
+}
+
+// testline creates a failing test for the error, with the stack traces as
+// diagnostic. The location is the innermost frame in the build-dir
+func (e memcheckError) testline(builddir string) *tap.Testline {
+	what := e.What
+	if what == "" {
+		what = e.XWhat
+	}
+	tl := &tap.Testline{Description: "Memcheck: " + what}
+
+	var diag bytes.Buffer
+	y := make(map[string]string)
+	for i, s := range e.Stacks {
+		if i == 0 {
+			fmt.Fprintln(&diag, what)
+		} else if i-1 < len(e.AuxWhat) {
+			fmt.Fprintln(&diag, e.AuxWhat[i-1])
+		}
+		for j, f := range s.Frames {
+			if j == 0 {
+				fmt.Fprintln(&diag, "   at", f)
+			} else {
+				fmt.Fprintln(&diag, "   by", f)
+			}
+			if _, ok := y["file"]; !ok && f.File != "" && path.Clean(f.Dir) == path.Clean(builddir) {
+				y["file"] = f.File
+				y["line"] = strconv.Itoa(f.Line)
+			}
+		}
+	}
+	tl.Diagnostic = diag.String()
+	if len(y) > 0 {
+		tl.Yaml = formatYAML(y)
+	}
+	return tl
+}
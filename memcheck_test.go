@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseMemcheck(t *testing.T) {
+	tcs := []struct {
+		xml  string
+		want []memcheckError
+	}{
+		{`<valgrindoutput></valgrindoutput>`, nil},
+		{
+			`<?xml version="1.0"?>
+<valgrindoutput>
+<error>
+  <kind>InvalidRead</kind>
+  <what>Invalid read of size 4</what>
+  <stack>
+    <frame><ip>0x1091A6</ip><obj>/tmp/bd/t</obj><fn>main</fn><dir>/tmp/bd</dir><file>t.cpp</file><line>6</line></frame>
+  </stack>
+  <auxwhat>Address 0x4a4c050 is 0 bytes after a block of size 16 alloc'd</auxwhat>
+  <stack>
+    <frame><ip>0x483B7F3</ip><obj>/usr/lib/valgrind/vgpreload_memcheck.so</obj><fn>operator new[](unsigned long)</fn></frame>
+  </stack>
+</error>
+<error>
+  <kind>Leak_StillReachable</kind>
+  <xwhat><text>8 bytes in 1 blocks are still reachable</text></xwhat>
+</error>
+<error>
+  <kind>Leak_DefinitelyLost</kind>
+  <xwhat><text>16 bytes in 1 blocks are definitely lost</text><leakedbytes>16</leakedbytes></xwhat>
+</error>
+</valgrindoutput>
+`,
+			[]memcheckError{
+				{
+					Kind:    "InvalidRead",
+					What:    "Invalid read of size 4",
+					AuxWhat: []string{"Address 0x4a4c050 is 0 bytes after a block of size 16 alloc'd"},
+					Stacks: []memcheckStack{
+						{[]memcheckFrame{{IP: "0x1091A6", Obj: "/tmp/bd/t", Fn: "main", Dir: "/tmp/bd", File: "t.cpp", Line: 6}}},
+						{[]memcheckFrame{{IP: "0x483B7F3", Obj: "/usr/lib/valgrind/vgpreload_memcheck.so", Fn: "operator new[](unsigned long)"}}},
+					},
+				},
+				{
+					Kind:  "Leak_DefinitelyLost",
+					XWhat: "16 bytes in 1 blocks are definitely lost",
+				},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		f, err := ioutil.TempFile("", "bor-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(tc.xml)
+		f.Close()
+
+		got, err := parseMemcheck(f.Name())
+		if err != nil {
+			t.Errorf("parseMemcheck(%q) failed: %v", tc.xml, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseMemcheck(%q) = %+v, want %+v", tc.xml, got, tc.want)
+		}
+	}
+
+	if _, err := parseMemcheck("/nonexistent"); err == nil {
+		t.Errorf("parseMemcheck(/nonexistent) succeeded")
+	}
+}
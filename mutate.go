@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// flips are the operators replaced by mutate and their replacements
+var flips = map[string]string{
+	"<":  "<=",
+	"<=": "<",
+	">":  ">=",
+	">=": ">",
+	"==": "!=",
+	"!=": "==",
+	"+":  "-",
+	"-":  "+",
+	"*":  "/",
+	"/":  "*",
+	"%":  "/",
+	"&&": "||",
+	"||": "&&",
+	"+=": "-=",
+	"-=": "+=",
+	"++": "--",
+	"--": "++",
+}
+
+// mutant is a single small change of a file of the request
+type mutant struct {
+	File   string
+	Line   int
+	Offset int // Of the changed source
+	Len    int // Of the changed source
+	Repl   string
+	Desc   string
+}
+
+// apply returns src with the mutation applied
+func (m mutant) apply(src []byte) []byte {
+	b := make([]byte, 0, len(src)-m.Len+len(m.Repl))
+	b = append(b, src[:m.Offset]...)
+	b = append(b, m.Repl...)
+	return append(b, src[m.Offset+m.Len:]...)
+}
+
+// mutants returns all mutants of the C++ source src. Operators are flipped
+// (e.g. "<" to "<="), integer constants changed by one, negations and whole
+// statements removed. Only code in function bodies is mutated, but many
+// mutants will still not compile (e.g. when "*" declares a pointer)
+func mutants(file string, src []byte) []mutant {
+	var ms []mutant
+	add := func(t token, n int, repl, desc string) {
+		ms = append(ms, mutant{file, t.Line, t.Offset, n, repl, desc})
+	}
+
+	toks := lex(src)
+
+	// code is a stack, telling for every open brace whether it opens a
+	// function body (or a block in one), as opposed to e.g. a class or an
+	// initializer
+	var code []bool
+	inCode := func() bool { return len(code) > 0 && code[len(code)-1] }
+	parens := 0
+	start := -1 // The first token of the current statement
+
+	for i, t := range toks {
+		if t.Kind == tokDirective || t.Kind == tokInclude {
+			start = -1
+			continue
+		}
+		if start < 0 && t.Text != "{" && t.Text != "}" && t.Text != ";" {
+			start = i
+		}
+
+		switch t.Kind {
+		case tokNumber:
+			if !inCode() {
+				continue
+			}
+			num := strings.TrimRight(t.Text, "uUlL")
+			n, err := strconv.ParseUint(num, 10, 64)
+			if err != nil || (len(num) > 1 && num[0] == '0') {
+				continue
+			}
+			add(t, len(num), strconv.FormatUint(n+1, 10), fmt.Sprintf("replaced %s with %d", num, n+1))
+			if n > 0 {
+				add(t, len(num), strconv.FormatUint(n-1, 10), fmt.Sprintf("replaced %s with %d", num, n-1))
+			}
+			continue
+		case tokPunct:
+		default:
+			continue
+		}
+
+		switch t.Text {
+		case "{":
+			c := inCode()
+			if start >= 0 {
+				c = toks[i-1].Text != "=" && !typeBody(toks[start:i])
+			}
+			code = append(code, c)
+			start = -1
+		case "}":
+			if len(code) > 0 {
+				code = code[:len(code)-1]
+			}
+			start = -1
+		case "(", "[":
+			parens++
+		case ")", "]":
+			parens--
+		case ";":
+			if parens > 0 {
+				continue
+			}
+			if start >= 0 && inCode() {
+				s := toks[start]
+				end := t.Offset + 1
+				add(s, end-s.Offset, "", fmt.Sprintf("removed %q", abbrev(string(src[s.Offset:end]))))
+			}
+			start = -1
+		case "!":
+			if inCode() {
+				add(t, 1, "", `removed "!"`)
+			}
+		default:
+			repl, ok := flips[t.Text]
+			if !ok || !inCode() || (i > 0 && toks[i-1].Text == "operator") {
+				continue
+			}
+			add(t, len(t.Text), repl, fmt.Sprintf("replaced %q with %q", t.Text, repl))
+		}
+	}
+	return ms
+}
+
+// typeBody returns whether a brace after the tokens of a statement opens the
+// body of a type, namespace or extern-block (as opposed to a function). Keywords
+// in template parameter lists (e.g. "template <class T> T f()") do not count
+func typeBody(toks []token) bool {
+	angle := 0 // The depth of template parameter lists
+	for i, u := range toks {
+		switch {
+		case u.Text == "<" && (angle > 0 || (i > 0 && toks[i-1].Text == "template")):
+			angle++
+		case u.Text == ">" && angle > 0:
+			angle--
+		case u.Text == ">>" && angle > 0:
+			if angle -= 2; angle < 0 {
+				angle = 0
+			}
+		case angle > 0:
+		case u.Text == "class", u.Text == "struct", u.Text == "union", u.Text == "enum", u.Text == "namespace", u.Text == "extern":
+			return true
+		}
+	}
+	return false
+}
+
+// abbrev collapses the whitespace in s and shortens it, if it is long
+func abbrev(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:59]) + "…"
+	}
+	return s
+}
+
+// mutantResult is the result of running the testsuites against a mutant
+type mutantResult struct {
+	compiled bool
+	killed   string // The first failing test, empty if the mutant survived
+	err      error
+}
+
+// runMutant runs the testsuites of msg with m applied
+func runMutant(msg *Message, m mutant) mutantResult {
+	// Only the testsuites matter, the rest would just take time
+	mm := *msg
+	mm.Scoring, mm.Coverage, mm.Rules = nil, nil, nil
+	mm.Files = make(map[string]File, len(msg.Files))
+	for name, f := range msg.Files {
+		mm.Files[name] = f
+	}
+	mm.Files[m.File] = File{b: m.apply(msg.Files[m.File].b)}
+
+	suites, err := runRequest(context.Background(), &mm)
+	if err != nil {
+		return mutantResult{err: err}
+	}
+	res := mutantResult{compiled: suites[0].Suite.Ok}
+	for _, s := range suites[1:] {
+		if s.Error != "" {
+			res.killed = s.Name + ": " + s.Error
+			break
+		}
+		if tl := firstFailure(s.Suite); tl != "" {
+			res.killed = s.Name + ": " + tl
+			break
+		}
+	}
+	return res
+}
+
+// firstFailure returns the description of the first failing test of s, or
+// the empty string if all passed
+func firstFailure(s Testsuite) string {
+	for _, tl := range s.Tests {
+		if !tl.Ok {
+			return tl.Description
+		}
+	}
+	if !s.Ok {
+		return "(suite failed)"
+	}
+	return ""
+}
+
+// mutate implements the subcommand "mutate": It generates mutants of some
+// files of a request (the reference solution), runs the testsuites against
+// every one and reports the mutation score (the fraction of mutants, that made
+// some test fail) and the mutants surviving. It returns the exit status
+func mutate(args []string) int {
+	fs := flag.NewFlagSet("mutate", flag.ExitOnError)
+	files := fs.String("files", "", "Comma-separated files of the request to mutate, e.g. the reference solution")
+	max := fs.Int("max", 0, "Maximum number of mutants to run, spread evenly over all of them (0 for all)")
+	jobs := fs.Int("j", 1, "Number of mutants to run in parallel")
+	min := fs.Float64("min", 0, "Minimum mutation score in percent, below which mutate fails")
+	verbose := fs.Bool("v", false, "Also report killed mutants and the test killing them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bor [-config FILE] mutate -files FILE,... [-max N] [-j N] [-min PERCENT] [-v] REQUEST")
+		fmt.Fprintln(os.Stderr, "REQUEST is a JSON-file in the format sent to the server, \"-\" for stdin")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *files == "" || *jobs < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	msg, err := readRequest(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var ms []mutant
+	for _, name := range strings.Split(*files, ",") {
+		f, ok := msg.Files[name]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "No such file:", name)
+			return 2
+		}
+		ms = append(ms, mutants(name, f.b)...)
+	}
+	if *max > 0 && len(ms) > *max {
+		sel := make([]mutant, *max)
+		for i := range sel {
+			sel[i] = ms[i*len(ms) / *max]
+		}
+		ms = sel
+	}
+
+	// Without mutations, every test has to pass, or the score means nothing.
+	// The empty mutant changes nothing
+	res := runMutant(msg, mutant{File: strings.Split(*files, ",")[0]})
+	if res.err != nil {
+		fmt.Fprintln(os.Stderr, "Could not create buildpath:", res.err)
+		return 2
+	}
+	if !res.compiled || res.killed != "" {
+		fmt.Println("ERROR The testsuites fail without mutations:")
+		if res.killed == "" {
+			res.killed = "Building"
+		}
+		printIndented(res.killed)
+		return 1
+	}
+
+	results := make([]mutantResult, len(ms))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for j := 0; j < *jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				results[i] = runMutant(msg, ms[i])
+				mu.Lock()
+				done++
+				fmt.Fprintf(os.Stderr, "Mutant %d/%d finished\n", done, len(ms))
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range ms {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+
+	lines := make(map[string][]string)
+	for _, name := range strings.Split(*files, ",") {
+		lines[name] = strings.Split(string(msg.Files[name].b), "\n")
+	}
+
+	var killed, survived, invalid int
+	for i, m := range ms {
+		r := results[i]
+		switch {
+		case r.err != nil:
+			fmt.Fprintln(os.Stderr, "Could not create buildpath:", r.err)
+			return 2
+		case !r.compiled:
+			invalid++
+			continue
+		case r.killed != "":
+			killed++
+			if !*verbose {
+				continue
+			}
+			fmt.Printf("KILLED   %s:%d: %s (%s)\n", m.File, m.Line, m.Desc, r.killed)
+		default:
+			survived++
+			fmt.Printf("SURVIVED %s:%d: %s\n", m.File, m.Line, m.Desc)
+		}
+		printIndented(strings.TrimSpace(lines[m.File][m.Line-1]))
+	}
+
+	score := 100.0
+	if killed+survived > 0 {
+		score = 100 * float64(killed) / float64(killed+survived)
+	}
+	fmt.Printf("Mutation score: %.1f%% (%d of %d mutants killed, %d did not compile)\n", score, killed, killed+survived, invalid)
+	if score < *min {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMutants(t *testing.T) {
+	tcs := []struct {
+		name string
+		src  string
+		want []string // "line: description" of mutants, that must be generated
+		not  []string // and of those, that must not
+	}{
+		{
+			name: "function",
+			src:  "int f(int a) {\n\treturn a < 1;\n}\n",
+			want: []string{`2: replaced "<" with "<="`, "2: replaced 1 with 2", "2: replaced 1 with 0", `2: removed "return a < 1;"`},
+		},
+		{
+			name: "template function",
+			src:  "template <class T, class U = std::vector<T>>\nT max(T a, T b) {\n\treturn a < b ? b : a;\n}\n",
+			want: []string{`3: replaced "<" with "<="`, `3: removed "return a < b ? b : a;"`},
+			not:  []string{`1: replaced "<" with "<="`},
+		},
+		{
+			name: "template class",
+			src:  "template <typename T>\nclass List {\n\tint n = 0;\n\tvoid push(T x) {\n\t\tn++;\n\t}\n};\n",
+			want: []string{`5: replaced "++" with "--"`, `5: removed "n++;"`},
+			not:  []string{"3: replaced 0 with 1", `3: removed "int n = 0;"`},
+		},
+		{
+			name: "class with member function",
+			src:  "struct A {\n\tint x = 1;\n\tint f() { return x + 2; }\n};\n",
+			want: []string{`3: replaced "+" with "-"`, "3: replaced 2 with 3"},
+			not:  []string{"2: replaced 1 with 2"},
+		},
+		{
+			name: "lambda",
+			src:  "int f() {\n\tauto g = [](int x) { return x * 3; };\n\treturn g(1);\n}\n",
+			want: []string{`2: replaced "*" with "/"`, "2: replaced 3 with 4", `3: removed "return g(1);"`},
+		},
+		{
+			name: "brace-init",
+			src:  "int a[] = {1, 2};\nint f() {\n\tint b[] = {3};\n\treturn a[0] + b[0];\n}\n",
+			want: []string{`4: replaced "+" with "-"`},
+			not:  []string{"1: replaced 1 with 2", "3: replaced 3 with 4"},
+		},
+		{
+			name: "operators and preprocessor",
+			src:  "#include <vector>\n#define N (1 + 2)\nbool operator<(A a, A b) {\n\treturn !(a == b);\n}\n",
+			want: []string{`4: removed "!"`, `4: replaced "==" with "!="`},
+			not:  []string{`3: replaced "<" with "<="`, `2: replaced "+" with "-"`},
+		},
+	}
+
+	for _, tc := range tcs {
+		got := make(map[string]bool)
+		for _, m := range mutants("x.cpp", []byte(tc.src)) {
+			got[fmt.Sprintf("%d: %s", m.Line, m.Desc)] = true
+		}
+		for _, w := range tc.want {
+			if !got[w] {
+				t.Errorf("%s: mutant %q missing, got %v", tc.name, w, got)
+			}
+		}
+		for _, n := range tc.not {
+			if got[n] {
+				t.Errorf("%s: unexpected mutant %q", tc.name, n)
+			}
+		}
+	}
+}
+
+func TestMutantApply(t *testing.T) {
+	src := []byte("if (a < b) {}")
+	for _, m := range mutants("x.cpp", []byte("void f() { "+string(src)+" }")) {
+		if m.Desc == `replaced "<" with "<="` {
+			got := string(m.apply([]byte("void f() { " + string(src) + " }")))
+			if want := "void f() { if (a <= b) {} }"; got != want {
+				t.Errorf("apply() = %q, want %q", got, want)
+			}
+			return
+		}
+	}
+	t.Errorf("no mutant replacing \"<\"")
+}
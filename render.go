@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats maps the names of all supported response-formats to the functions
+// rendering them. The empty name is the default. The renderers get passed the
+// request, to be able to refer to the submitted files
+var formats = map[string]func(io.Writer, *Message, []suiteWrap) error{
+	"":      renderJSON,
+	"json":  renderJSON,
+	"junit": renderJUnit,
+	"tap":   renderTAP,
+	"html":  renderHTML,
+}
+
+// renderJSON writes the suites as a JSON-array. This is the native format of
+// bor
+func renderJSON(w io.Writer, _ *Message, suites []suiteWrap) error {
+	return json.NewEncoder(w).Encode(suites)
+}
+
+// The following types describe the JUnit XML format, as understood by most CI
+// servers
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     float64      `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       float64         `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitCase     `xml:"testcase"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperties struct {
+	List []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name       string           `xml:"name,attr"`
+	Classname  string           `xml:"classname,attr"`
+	Time       float64          `xml:"time,attr,omitempty"`
+	File       string           `xml:"file,attr,omitempty"`
+	Line       int              `xml:"line,attr,omitempty"`
+	Properties *junitProperties `xml:"properties"`
+	Failure    *junitMessage    `xml:"failure"`
+	Error      *junitMessage    `xml:"error"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// suiteProperties returns the information about a suite, that the formats
+// other than JSON have no place of their own for, as name/value pairs: the
+// limits it hit, the seed its tests were shuffled with and the coverage of the
+// files. Durations are given in nanoseconds
+func suiteProperties(s suiteWrap) []junitProperty {
+	var p []junitProperty
+	if l := s.Stats.Limits; l != nil {
+		p = append(p,
+			junitProperty{"oom_kills", strconv.Itoa(l.OOMKills)},
+			junitProperty{"pids_max", strconv.Itoa(l.PidsMax)},
+			junitProperty{"cpu_throttled", strconv.FormatInt(int64(l.CPUThrottled), 10)})
+	}
+	if s.Seed != 0 {
+		p = append(p, junitProperty{"seed", strconv.FormatUint(uint64(s.Seed), 10)})
+	}
+	if s.Flaky {
+		p = append(p, junitProperty{"flaky", "true"})
+	}
+	for _, c := range s.Coverage {
+		p = append(p, junitProperty{"coverage " + c.File, fmt.Sprintf("%d/%d lines, %d/%d branches", c.LinesCovered, c.Lines, c.BranchesCovered, c.Branches)})
+	}
+	return p
+}
+
+// renderJUnit writes the suites as JUnit XML. Each suite becomes a testsuite,
+// its stats, score and the rest of suiteProperties become properties. An error
+// of a suite is reported as an additional testcase with an error-element,
+// named like the suite. The duration and location of a test are given as
+// attributes of its testcase, the expected and actual values and whether it
+// is flaky as its properties
+func renderJUnit(w io.Writer, _ *Message, suites []suiteWrap) error {
+	var all junitSuites
+
+	for _, s := range suites {
+		js := junitSuite{
+			Name:      s.Name,
+			Time:      (s.Stats.UserTime + s.Stats.SystemTime).Seconds(),
+			SystemOut: s.Output,
+			Properties: []junitProperty{
+				{"system_time", s.Stats.SystemTime.String()},
+				{"user_time", s.Stats.UserTime.String()},
+			},
+		}
+		if s.Score != nil {
+			js.Properties = append(js.Properties,
+				junitProperty{"points", strconv.FormatFloat(s.Score.Points, 'g', -1, 64)},
+				junitProperty{"max_points", strconv.FormatFloat(s.Score.Max, 'g', -1, 64)})
+		}
+		js.Properties = append(js.Properties, suiteProperties(s)...)
+
+		for _, tl := range s.Suite.Tests {
+			jc := junitCase{Name: tl.Description, Classname: s.Name}
+			y := parseYAML(tl.Yaml)
+			if us, err := strconv.ParseInt(y["duration_us"], 10, 64); err == nil {
+				jc.Time = (time.Duration(us) * time.Microsecond).Seconds()
+			}
+			jc.File = y["file"]
+			jc.Line, _ = strconv.Atoi(y["line"])
+			for _, k := range []string{"expected", "actual", "flaky"} {
+				if v, ok := y[k]; ok {
+					if jc.Properties == nil {
+						jc.Properties = new(junitProperties)
+					}
+					jc.Properties.List = append(jc.Properties.List, junitProperty{k, v})
+				}
+			}
+			if !tl.Ok {
+				jc.Failure = &junitMessage{firstLine(tl.Diagnostic), tl.Diagnostic}
+				js.Failures++
+			}
+			js.Cases = append(js.Cases, jc)
+		}
+		if s.Error != "" {
+			js.Cases = append(js.Cases, junitCase{
+				Name:      s.Name,
+				Classname: s.Name,
+				Error:     &junitMessage{s.Error, s.Output},
+			})
+			js.Errors++
+		}
+		js.Tests = len(js.Cases)
+
+		all.Tests += js.Tests
+		all.Failures += js.Failures
+		all.Errors += js.Errors
+		all.Time += js.Time
+		all.Suites = append(all.Suites, js)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(all); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// tapEscaper escapes descriptions of TAP test lines, where "#" starts a
+// directive and a newline would end the line
+var tapEscaper = strings.NewReplacer(`\`, `\\`, "#", `\#`, "\r\n", " ", "\n", " ", "\r", " ")
+
+// tapEscape escapes s for use as a description in a TAP test line
+func tapEscape(s string) string {
+	return tapEscaper.Replace(s)
+}
+
+// renderTAP writes the suites as a TAP version 13 stream. Every suite is a
+// test of the stream, with its tests as an indented subtest. The YAML-blocks
+// of the tests are passed on. Stats, score, errors and the rest of
+// suiteProperties of a suite are given in its YAML-block
+func renderTAP(w io.Writer, _ *Message, suites []suiteWrap) error {
+	var b strings.Builder
+
+	fmt.Fprintln(&b, "TAP version 13")
+	fmt.Fprintf(&b, "1..%d\n", len(suites))
+
+	for i, s := range suites {
+		fmt.Fprintf(&b, "# Subtest: %s\n", tapEscape(s.Name))
+		fmt.Fprintf(&b, "    1..%d\n", len(s.Suite.Tests))
+		for j, tl := range s.Suite.Tests {
+			if tl.Ok {
+				fmt.Fprintf(&b, "    ok %d - %s\n", j+1, tapEscape(tl.Description))
+			} else {
+				fmt.Fprintf(&b, "    not ok %d - %s\n", j+1, tapEscape(tl.Description))
+			}
+			// The YAML-block has to follow the test line directly
+			if y := parseYAML(tl.Yaml); len(y) > 0 {
+				for _, l := range strings.SplitAfter(string(formatYAML(y)), "\n") {
+					if l != "" {
+						b.WriteString("    " + l)
+					}
+				}
+			}
+			if tl.Diagnostic != "" {
+				for _, l := range strings.Split(strings.TrimRight(tl.Diagnostic, "\n"), "\n") {
+					fmt.Fprintf(&b, "    # %s\n", l)
+				}
+			}
+		}
+
+		if s.Suite.Ok && s.Error == "" {
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, tapEscape(s.Name))
+		} else {
+			fmt.Fprintf(&b, "not ok %d - %s\n", i+1, tapEscape(s.Name))
+		}
+		fmt.Fprintln(&b, "  ---")
+		fmt.Fprintf(&b, "  system_time: %d\n", s.Stats.SystemTime)
+		fmt.Fprintf(&b, "  user_time: %d\n", s.Stats.UserTime)
+		if s.Score != nil {
+			fmt.Fprintf(&b, "  points: %g\n", s.Score.Points)
+			fmt.Fprintf(&b, "  max_points: %g\n", s.Score.Max)
+		}
+		if s.Error != "" {
+			fmt.Fprintf(&b, "  error: %s\n", strconv.Quote(s.Error))
+		}
+		if s.Output != "" {
+			fmt.Fprintf(&b, "  output: %s\n", strconv.Quote(s.Output))
+		}
+		for _, p := range suiteProperties(s) {
+			v := p.Value
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil && v != "true" {
+				v = strconv.Quote(v)
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", p.Name, v)
+		}
+		fmt.Fprintln(&b, "  ...")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Merovius/go-tap"
+)
+
+func TestRenderTAP(t *testing.T) {
+	suites := []suiteWrap{{
+		Name: "a # b",
+		Suite: Testsuite{Ok: false, Tests: []*tap.Testline{
+			{Description: "x # TODO", Ok: true},
+			{Description: "two\nlines \\", Ok: false, Diagnostic: "first\nsecond\n"},
+		}},
+	}}
+	var b bytes.Buffer
+	if err := renderTAP(&b, &Message{}, suites); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# Subtest: a \\# b\n",
+		"    ok 1 - x \\# TODO\n",
+		"    not ok 2 - two lines \\\\\n",
+		"    # first\n    # second\n",
+		"not ok 1 - a \\# b\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("renderTAP output does not contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// Rules forbid the use of some parts of the language or library in some files
+// of the request, e.g. to make students implement a list themselves. The
+// rules are checked after the build and reported in a suite named "Rules"
+type Rules struct {
+	Files       []string `json:"files"`                 // The names of the files to check, e.g. "AccountsList.cpp"
+	Includes    []string `json:"includes,omitempty"`    // Headers, e.g. "list" or "<list>"
+	Identifiers []string `json:"identifiers,omitempty"` // Identifiers, e.g. "goto" or "std::list"
+	Calls       []string `json:"calls,omitempty"`       // Functions, that must not be called, e.g. "system"
+	Symbols     []string `json:"symbols,omitempty"`     // Patterns (see path.Match) of demangled symbols, the object-files must not use, e.g. "*std::__cxx11::list<*"
+}
+
+// violation is a single violation of a rule
+type violation struct {
+	File string
+	Line int // 0, if unknown
+	Text string
+}
+
+// runRules checks the rules and creates a suite with a test for every rule,
+// that fails if it is violated anywhere. The symbols of the object-files are
+// read with nm in the make-sandbox
+func (r *runner) runRules(rules *Rules) suiteWrap {
+	wrap := suiteWrap{Name: "Rules", Suite: Testsuite{Ok: true}}
+
+	type check struct {
+		desc string
+		viol []violation
+	}
+	var checks []*check
+	includes := make(map[string]*check)
+	idents := make(map[string]*check)
+	calls := make(map[string]*check)
+	for _, s := range rules.Includes {
+		h := strings.Trim(s, `<>"`)
+		includes[h] = &check{desc: fmt.Sprintf("Forbidden include <%s>", h)}
+		checks = append(checks, includes[h])
+	}
+	for _, s := range rules.Identifiers {
+		idents[s] = &check{desc: "Forbidden identifier " + s}
+		checks = append(checks, idents[s])
+	}
+	for _, s := range rules.Calls {
+		calls[s] = &check{desc: "Forbidden call " + s}
+		checks = append(checks, calls[s])
+	}
+
+	for _, name := range rules.Files {
+		f, ok := r.msg.Files[name]
+		if !ok {
+			wrap.Error = "No such file: " + name
+			return wrap
+		}
+
+		var scan func(toks []token, line int)
+		scan = func(toks []token, line int) {
+			for i, t := range toks {
+				switch t.Kind {
+				case tokInclude:
+					if c, ok := includes[strings.Trim(t.Text, `<>"`)]; ok {
+						c.viol = append(c.viol, violation{name, line + t.Line, "#include " + t.Text})
+					}
+					continue
+				case tokDirective:
+					// Forbidden things can be hidden in macros
+					scan(lex([]byte(strings.TrimSpace(t.Text)[1:])), line+t.Line-1)
+					continue
+				}
+				for s, c := range idents {
+					if n := matchQualified(toks, i, s); n > 0 {
+						c.viol = append(c.viol, violation{name, line + t.Line, s})
+					}
+				}
+				for s, c := range calls {
+					if n := matchQualified(toks, i, s); n > 0 && i+n < len(toks) && toks[i+n].Text == "(" {
+						c.viol = append(c.viol, violation{name, line + t.Line, s + "(…)"})
+					}
+				}
+			}
+		}
+		scan(lex(f.b), 0)
+	}
+
+	if len(rules.Symbols) > 0 {
+		syms, out, err := r.symbols(rules.Files)
+		if err != nil {
+			wrap.Error = err.Error()
+			wrap.Output = string(out)
+			return wrap
+		}
+		for _, pat := range rules.Symbols {
+			c := &check{desc: "Forbidden symbol " + pat}
+			checks = append(checks, c)
+			for _, s := range syms {
+				if ok, _ := path.Match(pat, s.Text); ok {
+					c.viol = append(c.viol, s)
+				}
+			}
+		}
+	}
+
+	for i, c := range checks {
+		tl := &tap.Testline{Num: uint(i + 1), Description: c.desc, Ok: len(c.viol) == 0}
+		var diag bytes.Buffer
+		for _, v := range c.viol {
+			if v.Line > 0 {
+				fmt.Fprintf(&diag, "%s:%d: %s\n", v.File, v.Line, v.Text)
+			} else {
+				fmt.Fprintf(&diag, "%s: %s\n", v.File, v.Text)
+			}
+		}
+		tl.Diagnostic = diag.String()
+		if len(c.viol) > 0 {
+			y := map[string]string{"file": c.viol[0].File}
+			if c.viol[0].Line > 0 {
+				y["line"] = strconv.Itoa(c.viol[0].Line)
+			}
+			tl.Yaml = formatYAML(y)
+		}
+		wrap.Suite.Ok = wrap.Suite.Ok && tl.Ok
+		wrap.Suite.Tests = append(wrap.Suite.Tests, tl)
+	}
+	return wrap
+}
+
+// matchQualified checks whether the tokens starting at toks[i] are the
+// (possibly qualified) name s and returns the number of matched tokens. An
+// unqualified name also matches the last part of a qualified one. Members
+// accessed with "." or "->" (e.g. obj.system()) never match
+func matchQualified(toks []token, i int, s string) int {
+	if i > 0 && (toks[i-1].Text == "." || toks[i-1].Text == "->") {
+		return 0
+	}
+	parts := strings.Split(s, "::")
+	n := 0
+	for j, p := range parts {
+		if j > 0 {
+			if i+n >= len(toks) || toks[i+n].Text != "::" {
+				return 0
+			}
+			n++
+		}
+		if i+n >= len(toks) || toks[i+n].Kind != tokIdent || toks[i+n].Text != p {
+			return 0
+		}
+		n++
+	}
+	// With "list", "std::list" matches at "list", but with "std::list",
+	// "foo::std::list" should not match at "std"
+	if len(parts) > 1 && i > 0 && toks[i-1].Text == "::" {
+		return 0
+	}
+	return n
+}
+
+// symbols returns the demangled symbols of the object-files of the given C++
+// sources (other files are ignored). These are the symbols used, but not
+// defined, as well as those defined, as templates and inline functions (e.g.
+// std::sort) are instantiated in the object-files using them
+func (r *runner) symbols(files []string) ([]violation, []byte, error) {
+	var objs, srcs []string
+	for _, f := range files {
+		if strings.HasSuffix(f, ".cpp") {
+			objs = append(objs, strings.TrimSuffix(f, ".cpp")+".o")
+			srcs = append(srcs, f)
+		}
+	}
+	if len(objs) == 0 {
+		return nil, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, conf.MakeTimeout)
+	defer cancel()
+	args := append([]string{"--demangle", "--print-file-name"}, objs...)
+	cmd := sandbox.CommandContext(ctx, conf.MakeSandbox, "nm", args...)
+	cmd.SetDir(r.builddir)
+	out, err := sandbox.CombinedOutput(cmd)
+	if err != nil {
+		return nil, out, err
+	}
+
+	src := make(map[string]string)
+	for i := range objs {
+		src[objs[i]] = srcs[i]
+	}
+
+	// The lines look like "file.o:0000000000000000 W symbol", the value is
+	// left blank for undefined symbols. A symbol can be listed more than once
+	var syms []violation
+	seen := make(map[violation]bool)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		i := strings.Index(line, ".o:")
+		if i < 0 {
+			continue
+		}
+		rest := strings.TrimLeft(line[i+3:], " ")
+		if len(rest) > 1 && rest[1] != ' ' {
+			// Skip the value
+			if j := strings.IndexByte(rest, ' '); j >= 0 {
+				rest = rest[j+1:]
+			}
+		}
+		if len(rest) < 3 || rest[1] != ' ' {
+			continue
+		}
+		v := violation{src[line[:i+2]], 0, rest[2:]}
+		if !seen[v] {
+			seen[v] = true
+			syms = append(syms, v)
+		}
+	}
+	return syms, nil, nil
+}
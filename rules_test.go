@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchQualified(t *testing.T) {
+	tcs := []struct {
+		src  string
+		i    int
+		s    string
+		want int
+	}{
+		{"list x;", 0, "list", 1},
+		{"std::list x;", 2, "list", 1},
+		{"std::list x;", 0, "std::list", 3},
+		{"list x;", 0, "std::list", 0},
+		{"foo::std::list x;", 2, "std::list", 0},
+		{"std::vector x;", 0, "std::list", 0},
+		{"obj.system();", 2, "system", 0},
+		{"this->list.clear();", 2, "list", 0},
+		{"p->std::list::size();", 2, "std::list", 0},
+		{"std::system(\"ls\");", 2, "system", 1},
+	}
+
+	for _, tc := range tcs {
+		if got := matchQualified(lex([]byte(tc.src)), tc.i, tc.s); got != tc.want {
+			t.Errorf("matchQualified(lex(%q), %d, %q) = %d, want %d", tc.src, tc.i, tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestRunRules(t *testing.T) {
+	src := "#include <cstdlib>\n" +
+		"#define RUN(x) \\\n" +
+		"\tstd::system(x)\n" +
+		"int main() {\n" +
+		"\tobj.system(\"ls\");\n" +
+		"\tconst char *s = R\"(system(\"ls\")\n)\"; system(s);\n" +
+		"}\n"
+	r := &runner{msg: &Message{Files: map[string]File{"a.cpp": {[]byte(src)}}}}
+	wrap := r.runRules(&Rules{
+		Files:    []string{"a.cpp"},
+		Includes: []string{"<cstdlib>", "list"},
+		Calls:    []string{"system"},
+	})
+	if wrap.Error != "" {
+		t.Fatalf("runRules failed: %s", wrap.Error)
+	}
+
+	want := []struct {
+		desc string
+		ok   bool
+		diag string
+	}{
+		{"Forbidden include <cstdlib>", false, "a.cpp:1: #include <cstdlib>\n"},
+		{"Forbidden include <list>", true, ""},
+		{"Forbidden call system", false, "a.cpp:3: system(…)\na.cpp:7: system(…)\n"},
+	}
+	if len(wrap.Suite.Tests) != len(want) {
+		t.Fatalf("runRules returned %d tests, want %d", len(wrap.Suite.Tests), len(want))
+	}
+	for i, w := range want {
+		tl := wrap.Suite.Tests[i]
+		if tl.Description != w.desc || tl.Ok != w.ok || tl.Diagnostic != w.diag {
+			t.Errorf("test %d = (%q, %v, %q), want (%q, %v, %q)", i+1, tl.Description, tl.Ok, tl.Diagnostic, w.desc, w.ok, w.diag)
+		}
+	}
+	if wrap.Suite.Ok {
+		t.Error("runRules suite is ok, despite violations")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// runner runs the testsuites of a request
+type runner struct {
+	ctx      context.Context
+	builddir string
+	msg      *Message
+}
+
+// command returns a command running name in the test-sandbox, with the limits
+// of the request. The command is killed after timeout or when the request is
+// canceled. cancel must be called, once the command is finished
+func (r *runner) command(timeout time.Duration, name string, arg ...string) (cmd sandbox.Cmd, cancel context.CancelFunc) {
+	var l sandbox.Limits
+	if r.msg.Limits != nil {
+		l = *r.msg.Limits
+	}
+	ctx, cancel := context.WithTimeout(r.ctx, timeout)
+	cmd = sandbox.CommandLimits(ctx, conf.TestSandbox, l, name, arg...)
+	cmd.SetDir(r.builddir)
+	return cmd, cancel
+}
+
+// runSuite runs the testsuite described by spec, which already has been built
+func (r *runner) runSuite(spec Suite) cmdResult {
+	var seed uint32
+	if spec.Type == "" {
+		seed = spec.Seed
+		if seed == 0 && spec.Shuffle {
+			seed = newSeed()
+		}
+	}
+	res := r.run(spec, seed)
+	res.seed = seed
+	if spec.Reruns > 0 {
+		r.rerun(spec, &res)
+	}
+	if spec.Type == "" && spec.Sanitize && res.err == nil {
+		r.runSanitized(spec, &res)
+	}
+	return res
+}
+
+// run runs the tests of spec once. The tests of a CppUnit-testsuite are
+// shuffled with seed, unless it is 0 (see TAPListener.cpp)
+func (r *runner) run(spec Suite, seed uint32) cmdResult {
+	switch spec.Type {
+	case "io":
+		return r.runIO(spec)
+	case "analyze":
+		return r.runAnalyze(spec)
+	case "benchmark":
+		return r.runBenchmark(spec)
+	}
+	var arg []string
+	if seed != 0 {
+		arg = append(arg, fmt.Sprintf("--seed=%d", seed))
+	}
+	switch spec.Isolate {
+	case "test", "fixture":
+		return r.runIsolated(spec, arg...)
+	}
+	return r.runTAP(spec, arg...)
+}
+
+// suiteTimeout returns the timeout for a run of the program of spec, i.e. the
+// MemcheckTimeout with memcheck and the TestTimeout otherwise
+func suiteTimeout(spec Suite) time.Duration {
+	if spec.Memcheck {
+		return conf.MemcheckTimeout
+	}
+	return conf.TestTimeout
+}
+
+// runTAP runs the testsuite-executable of spec with the given arguments in the
+// test-sandbox and parses its output. With memcheck, the errors found by
+// valgrind are added as failing tests
+func (r *runner) runTAP(spec Suite, arg ...string) cmdResult {
+	var res cmdResult
+
+	name, timeout := path.Join(r.builddir, spec.Name), suiteTimeout(spec)
+	var xmlfile string
+	if spec.Memcheck {
+		f, err := ioutil.TempFile(r.builddir, spec.Name+".memcheck-*.xml")
+		if err != nil {
+			res.err = err
+			return res
+		}
+		f.Close()
+		xmlfile = f.Name()
+		arg = append(memcheckArgs(xmlfile, name), arg...)
+		name = "valgrind"
+	}
+
+	cmd, cancel := r.command(timeout, name, arg...)
+	defer cancel()
+	out, err := sandbox.CombinedOutput(cmd)
+	if ev, ok := sandbox.Events(cmd); ok && ev.Any() {
+		res.stats.Limits = &ev
+	}
+	if err != nil {
+		elog.Println("Could not run testsuite: ", err)
+		res.output = out
+		res.err = err
+		return res
+	}
+	res.stats.UserTime = cmd.ProcessState().UserTime()
+	res.stats.SystemTime = cmd.ProcessState().SystemTime()
+
+	// Parse the TAP
+	parser, err := tap.NewParser(bytes.NewReader(out))
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	suite, err := parser.Suite()
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	if spec.Memcheck {
+		errs, err := parseMemcheck(xmlfile)
+		if err != nil {
+			res.err = err
+			return res
+		}
+		for _, e := range errs {
+			tl := e.testline(r.builddir)
+			tl.Num = uint(len(suite.Tests) + 1)
+			suite.Tests = append(suite.Tests, tl)
+			suite.Ok = false
+		}
+	}
+
+	res.suite = (*Testsuite)(suite)
+	return res
+}
+
+// runIsolated runs every test (or every fixture, depending on spec.Isolate)
+// of a testsuite in its own process, each with its own timeout, so a test that
+// crashes or hangs only fails itself (or its fixture). The arguments are given
+// to the testsuite when listing its tests, e.g. to shuffle them
+func (r *runner) runIsolated(spec Suite, arg ...string) cmdResult {
+	var res cmdResult
+
+	// Ask the testsuite which tests it contains
+	cmd, cancel := r.command(conf.TestTimeout, path.Join(r.builddir, spec.Name), append([]string{"--list"}, arg...)...)
+	out, err := sandbox.CombinedOutput(cmd)
+	cancel()
+	if err != nil {
+		res.output = out
+		res.err = err
+		return res
+	}
+	names := strings.Fields(string(out))
+
+	// Group the tests to run them together. The order of the tests is
+	// preserved
+	var groups [][]string
+	index := make(map[string]int)
+	for _, name := range names {
+		g := name
+		if spec.Isolate == "fixture" {
+			if i := strings.LastIndex(name, "::"); i >= 0 {
+				g = name[:i]
+			}
+		}
+		if i, ok := index[g]; ok {
+			groups[i] = append(groups[i], name)
+			continue
+		}
+		index[g] = len(groups)
+		groups = append(groups, []string{name})
+	}
+
+	suite := &Testsuite{Ok: true}
+	for _, g := range groups {
+		// We give the names of all tests instead of the fixture, to keep
+		// the order stable
+		gr := r.runTAP(spec, g...)
+		res.stats.add(gr.stats)
+
+		var tests []*tap.Testline
+		if gr.err != nil {
+			// The process failed as a whole, so all its tests fail
+			diag := gr.err.Error()
+			if len(gr.output) > 0 {
+				diag = fmt.Sprintf("%s\n%s", gr.err, gr.output)
+			}
+			for _, name := range g {
+				tests = append(tests, &tap.Testline{Description: name, Diagnostic: diag})
+			}
+		} else {
+			tests = gr.suite.Tests
+		}
+
+		for _, tl := range tests {
+			tl.Num = uint(len(suite.Tests) + 1)
+			suite.Ok = suite.Ok && tl.Ok
+			suite.Tests = append(suite.Tests, tl)
+		}
+	}
+
+	res.suite = suite
+	return res
+}
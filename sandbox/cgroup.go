@@ -0,0 +1,308 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	goconf "code.google.com/p/goconf/conf"
+)
+
+var (
+	// cgroupRoot is a delegated cgroup v2, in which a cgroup is created for
+	// every command with limits
+	cgroupRoot = ""
+
+	// cgroupID is used to give the cgroups unique names
+	cgroupID int64
+
+	// limits contains the configured limits for every driver
+	limits = make(map[string]Limits)
+)
+
+// Limits are resource-limits for a command, enforced with cgroup v2. Zero
+// values mean no limit
+type Limits struct {
+	Memory int64   `json:"memory,omitempty"` // Maximum memory usage in bytes
+	Pids   int     `json:"pids,omitempty"`   // Maximum number of processes and threads
+	CPU    float64 `json:"cpu,omitempty"`    // Maximum number of CPUs to use, e.g. 0.5
+}
+
+// Override returns l with all limits that are set in o replaced
+func (l Limits) Override(o Limits) Limits {
+	if o.Memory != 0 {
+		l.Memory = o.Memory
+	}
+	if o.Pids != 0 {
+		l.Pids = o.Pids
+	}
+	if o.CPU != 0 {
+		l.CPU = o.CPU
+	}
+	return l
+}
+
+// zero returns whether no limits are set
+func (l Limits) zero() bool {
+	return l == Limits{}
+}
+
+// LimitEvents counts how often a command ran into its limits
+type LimitEvents struct {
+	OOMKills     int           `json:"oom_kills,omitempty"`     // Processes killed because of the memory limit
+	PidsMax      int           `json:"pids_max,omitempty"`      // Forks that failed because of the pids limit
+	CPUThrottled time.Duration `json:"cpu_throttled,omitempty"` // Time the command was throttled because of the CPU limit
+}
+
+// Add adds the events in o to e
+func (e *LimitEvents) Add(o LimitEvents) {
+	e.OOMKills += o.OOMKills
+	e.PidsMax += o.PidsMax
+	e.CPUThrottled += o.CPUThrottled
+}
+
+// Any returns whether any limit was hit
+func (e LimitEvents) Any() bool {
+	return e != LimitEvents{}
+}
+
+// LimitError is returned by Wait, if a command was killed because it exceeded
+// its memory limit
+type LimitError struct {
+	LimitEvents
+}
+
+// Error implements the builtin error interface
+func (e LimitError) Error() string {
+	return "Memory limit exceeded"
+}
+
+// Execer is implemented by commands that run as a local *exec.Cmd. Only these
+// can be put into a cgroup
+type Execer interface {
+	Exec() *exec.Cmd
+}
+
+// limitCmd wraps a Cmd and runs it in its own cgroup
+type limitCmd struct {
+	Cmd
+	limits Limits
+	dir    string
+	events LimitEvents
+}
+
+// CommandLimits works like CommandContext, but the limits configured for the
+// driver are overridden by l
+func CommandLimits(ctx context.Context, driver string, l Limits, name string, arg ...string) Cmd {
+	dr, ok := drivers[driver]
+	if !ok {
+		panic(fmt.Errorf("No such Sandbox driver: %s", driver))
+	}
+	cmd := dr.Command(name, arg...)
+	l = limits[driver].Override(l)
+	if !l.zero() {
+		cmd = &limitCmd{Cmd: cmd, limits: l}
+	}
+	if ctx.Done() != nil {
+		cmd = &ctxCmd{Cmd: cmd, ctx: ctx}
+	}
+	return cmd
+}
+
+// Events returns the limit events of a command that has finished. If the
+// command did not run with limits, ok is false
+func Events(cmd Cmd) (ev LimitEvents, ok bool) {
+	if c, ok := cmd.(*ctxCmd); ok {
+		cmd = c.Cmd
+	}
+	if c, ok := cmd.(*limitCmd); ok {
+		return c.events, true
+	}
+	return LimitEvents{}, false
+}
+
+// write writes the value to a control file of the cgroup
+func (c *limitCmd) write(file, value string) error {
+	return ioutil.WriteFile(filepath.Join(c.dir, file), []byte(value), 0644)
+}
+
+// Start creates a cgroup with the limits and starts the command in it
+func (c *limitCmd) Start() error {
+	if cgroupRoot == "" {
+		return fmt.Errorf("Limits given, but no cgroup configured")
+	}
+	e, ok := c.Cmd.(Execer)
+	if !ok {
+		return fmt.Errorf("Sandbox does not support limits")
+	}
+	cmd := e.Exec()
+
+	name := fmt.Sprintf("bor-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupID, 1))
+	c.dir = filepath.Join(cgroupRoot, name)
+	if err := os.Mkdir(c.dir, 0755); err != nil {
+		return err
+	}
+
+	if c.limits.Memory != 0 {
+		if err := c.write("memory.max", strconv.FormatInt(c.limits.Memory, 10)); err != nil {
+			c.remove()
+			return err
+		}
+		// Without this, exceeding the limit would only lead to swapping.
+		// Not every system has swap accounting, so errors are ignored
+		c.write("memory.swap.max", "0")
+	}
+	if c.limits.Pids != 0 {
+		if err := c.write("pids.max", strconv.Itoa(c.limits.Pids)); err != nil {
+			c.remove()
+			return err
+		}
+	}
+	if c.limits.CPU != 0 {
+		period := 100000
+		quota := int(c.limits.CPU * float64(period))
+		if err := c.write("cpu.max", fmt.Sprintf("%d %d", quota, period)); err != nil {
+			c.remove()
+			return err
+		}
+	}
+
+	fd, err := os.Open(c.dir)
+	if err != nil {
+		c.remove()
+		return err
+	}
+	defer fd.Close()
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+
+	if err := c.Cmd.Start(); err != nil {
+		c.remove()
+		return err
+	}
+	return nil
+}
+
+// readKeyed reads the value of key from a flat keyed control file of the
+// cgroup, like memory.events
+func (c *limitCmd) readKeyed(file, key string) int64 {
+	f, err := os.Open(filepath.Join(c.dir, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// remove kills all processes remaining in the cgroup and removes it
+func (c *limitCmd) remove() {
+	c.write("cgroup.kill", "1")
+	// The cgroup can only be removed, once all processes are gone
+	for i := 0; i < 100; i++ {
+		if c.readKeyed("cgroup.events", "populated") == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	os.Remove(c.dir)
+}
+
+// Wait waits for the command to exit, collects the limit events and removes
+// the cgroup. If the command was killed because of the memory limit, a
+// LimitError is returned
+func (c *limitCmd) Wait() error {
+	err := c.Cmd.Wait()
+
+	c.events.OOMKills = int(c.readKeyed("memory.events", "oom_kill"))
+	c.events.PidsMax = int(c.readKeyed("pids.events", "max"))
+	c.events.CPUThrottled = time.Duration(c.readKeyed("cpu.stat", "throttled_usec")) * time.Microsecond
+	c.remove()
+
+	if c.events.OOMKills > 0 {
+		return LimitError{c.events}
+	}
+	return err
+}
+
+// awaitExit waits for the command to exit without reaping it, if the wrapped
+// command supports it (see exitWaiter)
+func (c *limitCmd) awaitExit() error {
+	if ew, ok := c.Cmd.(exitWaiter); ok {
+		return ew.awaitExit()
+	}
+	return fmt.Errorf("Can not wait for exit")
+}
+
+// Run starts the command and waits for it to exit
+func (c *limitCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its stdout
+func (c *limitCmd) Output() ([]byte, error) {
+	var b bytes.Buffer
+	c.SetStdout(&b)
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its stdout and stderr
+func (c *limitCmd) CombinedOutput() ([]byte, error) {
+	var b bytes.Buffer
+	c.SetStdout(&b)
+	c.SetStderr(&b)
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// Kill kills the command and everything else in its cgroup
+func (c *limitCmd) Kill() error {
+	err := c.Cmd.Kill()
+	c.write("cgroup.kill", "1")
+	return err
+}
+
+// configLimits reads the limits of the driver name from its config section
+func configLimits(cfg *goconf.ConfigFile, name string) (Limits, error) {
+	var l Limits
+	if str, err := cfg.GetString(name, "MemoryLimit"); err == nil {
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return l, fmt.Errorf("Invalid MemoryLimit for %s: %s", name, str)
+		}
+		l.Memory = n
+	}
+	if num, err := cfg.GetInt(name, "PidsLimit"); err == nil {
+		l.Pids = num
+	}
+	if f, err := cfg.GetFloat(name, "CPULimit"); err == nil {
+		l.CPU = f
+	}
+	return l, nil
+}
@@ -101,10 +101,10 @@ func (w *OffsetWriter) Write(p []byte) (n int, err error) {
 		return len(p), nil
 	}
 
+	w.i = w.n
 	m, err := w.w.Write(p[todo:])
-	w.i += m
 
-	return m + todo, nil
+	return m + todo, err
 }
 
 // Driver implements the sandbox-interface
@@ -112,13 +112,13 @@ type Driver struct{}
 
 // Cmd wraps a *exec.Cmd in delicious sandboxing
 type Cmd struct {
-	*exec.Cmd
+	sandbox.ExecCmd
 }
 
 // CombinedOutput returns the combined stderr and stdout of the command,
 // stripping away the magic of EasySandbox
 func (c Cmd) CombinedOutput() ([]byte, error) {
-	out, err := c.Cmd.CombinedOutput()
+	out, err := c.ExecCmd.CombinedOutput()
 
 	if !bytes.HasPrefix(out, magic) {
 		return out, EasySandboxError("Magic not found")
@@ -136,7 +136,7 @@ func (c Cmd) CombinedOutput() ([]byte, error) {
 // Output returns the stdout of the command, stripping away the magic of
 // EasySandbox
 func (c Cmd) Output() ([]byte, error) {
-	out, err := c.Cmd.Output()
+	out, err := c.ExecCmd.Output()
 	if err != nil {
 		return out, err
 	}
@@ -165,26 +165,6 @@ func (c Cmd) StdoutPipe() (io.ReadCloser, error) {
 	return r, err
 }
 
-// Dir returns the current working directory of the command
-func (c Cmd) Dir() string {
-	return c.Cmd.Dir
-}
-
-// SetDir sets the working directory of the command
-func (c Cmd) SetDir(dir string) {
-	c.Cmd.Dir = dir
-}
-
-// ProcessState returns the *os.ProcessState of the underlying *exec.Cmd
-func (c Cmd) ProcessState() sandbox.ProcessState {
-	return c.Cmd.ProcessState
-}
-
-// Kill sends a SIGKILL to the underlying *os.Process
-func (c Cmd) Kill() error {
-	return c.Cmd.Process.Kill()
-}
-
 // SetStdout sets the stdout to the given writer, throwing away the EasySandbox
 // magic
 func (c Cmd) SetStdout(w io.Writer) {
@@ -202,7 +182,7 @@ func (c Cmd) SetStderr(w io.Writer) {
 // Command returns a new command-struct, with the necessary environment for
 // EasySandbox
 func (d Driver) Command(name string, arg ...string) sandbox.Cmd {
-	ret := Cmd{exec.Command(name, arg...)}
+	ret := Cmd{sandbox.ExecCmd{Cmd: exec.Command(name, arg...)}}
 	ret.Cmd.Env = []string{
 		"LD_PRELOAD=" + path,
 		fmt.Sprintf("EASYSANDBOX_HEAPSIZE=%d", heap),
@@ -0,0 +1,26 @@
+package easysandbox
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOffsetWriter(t *testing.T) {
+	tcs := [][]string{
+		{"<<entering SECCOMP mode>>\nhello"},
+		{"<<entering", " SECCOMP mode>>\n", "hel", "lo"},
+		{"<<entering SECCOMP mode>>\nhe", "llo"},
+	}
+	for _, writes := range tcs {
+		var b bytes.Buffer
+		w := NewOffsetWriter(&b, len(magic))
+		for _, s := range writes {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("Write(%q) = %d, %v, want %d, nil", s, n, err, len(s))
+			}
+		}
+		if got := b.String(); got != "hello" {
+			t.Errorf("writing %q gave %q, want %q", writes, got, "hello")
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package sandbox
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Constants for waitid(2), which are not defined in package syscall
+const (
+	pPid    = 1
+	wNowait = 0x1000000
+)
+
+// waitDelay is how long Wait waits for the output-pipes to be closed, after the
+// command exited or was killed
+const waitDelay = 100 * time.Millisecond
+
+// ExecCmd implements the Cmd interface by wrapping an *exec.Cmd. It can be
+// used by all drivers, that run commands as local processes.
+//
+// The command is run in its own process group. Kill kills the whole group and
+// Wait only returns after all processes in the group are gone, so forked
+// processes (e.g. compilers started by make -j) do not outlive the command.
+// Processes that leave the process group (e.g. with setsid) are not caught,
+// use a cgroup (see Limits) or a PID namespace for that. If they still hold the
+// output-pipes, Wait closes them after waitDelay.
+type ExecCmd struct {
+	*exec.Cmd
+}
+
+// Dir returns the current working directory of the command
+func (c ExecCmd) Dir() string {
+	return c.Cmd.Dir
+}
+
+// SetDir sets the working directory of the command
+func (c ExecCmd) SetDir(dir string) {
+	c.Cmd.Dir = dir
+}
+
+// ProcessState returns the *os.ProcessState of the underlying *exec.Cmd, or
+// nil if it has not run (e.g. because it could not be started)
+func (c ExecCmd) ProcessState() ProcessState {
+	if c.Cmd.ProcessState == nil {
+		return nil
+	}
+	return c.Cmd.ProcessState
+}
+
+// Start starts the command in a new process group
+func (c ExecCmd) Start() error {
+	if c.Cmd.SysProcAttr == nil {
+		c.Cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.Cmd.SysProcAttr.Setpgid = true
+	c.Cmd.WaitDelay = waitDelay
+	return c.Cmd.Start()
+}
+
+// awaitExit waits for the command to exit, without reaping it (see
+// exitWaiter)
+func (c ExecCmd) awaitExit() error {
+	if c.Cmd.Process == nil {
+		return nil
+	}
+	return waitExit(c.Cmd.Process.Pid)
+}
+
+// Kill sends a SIGKILL to the process group of the command
+func (c ExecCmd) Kill() error {
+	if c.Cmd.Process == nil {
+		return nil
+	}
+	syscall.Kill(-c.Cmd.Process.Pid, syscall.SIGKILL)
+	return c.Cmd.Process.Kill()
+}
+
+// waitExit waits for the process pid to exit, without reaping it
+func waitExit(pid int) error {
+	// Big enough for a siginfo_t
+	var info [128]byte
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid), uintptr(unsafe.Pointer(&info[0])), syscall.WEXITED|wNowait, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+// groupAlive returns whether any process in the process group pgid is still
+// running. Zombies don't count, as they are already dead and we can not
+// influence when init reaps them
+func groupAlive(pgid int) bool {
+	if syscall.Kill(-pgid, 0) == syscall.ESRCH {
+		return false
+	}
+
+	d, err := os.Open("/proc")
+	if err != nil {
+		return true
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return true
+	}
+
+	pg := strconv.Itoa(pgid)
+	for _, n := range names {
+		if n[0] < '0' || n[0] > '9' {
+			continue
+		}
+		b, err := ioutil.ReadFile("/proc/" + n + "/stat")
+		if err != nil {
+			continue
+		}
+		// The name of the command is in parentheses and can contain
+		// anything, so we start parsing after it. The following fields
+		// are state, ppid and pgrp
+		i := bytes.LastIndexByte(b, ')')
+		if i < 0 {
+			continue
+		}
+		f := strings.Fields(string(b[i+1:]))
+		if len(f) >= 3 && f[2] == pg && f[0] != "Z" {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait waits for the command to exit. All remaining processes in its process
+// group are killed and Wait returns after they are gone
+func (c ExecCmd) Wait() error {
+	if c.Cmd.Process != nil {
+		pid := c.Cmd.Process.Pid
+		// Wait for the command itself, then kill the rest of the group
+		// before the command is reaped, so the process group can not be
+		// reused. Otherwise exec.Cmd.Wait would wait for all processes
+		// holding the output-pipes
+		if waitExit(pid) == nil {
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+		defer func() {
+			// The killed processes are reaped by init, which may take
+			// a moment
+			for i := 0; i < 100; i++ {
+				syscall.Kill(-pid, syscall.SIGKILL)
+				if !groupAlive(pid) {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	return c.Cmd.Wait()
+}
+
+// Run starts the command and waits for it to exit
+func (c ExecCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its stdout
+func (c ExecCmd) Output() ([]byte, error) {
+	var b bytes.Buffer
+	c.Cmd.Stdout = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its stdout and stderr
+func (c ExecCmd) CombinedOutput() ([]byte, error) {
+	var b bytes.Buffer
+	c.Cmd.Stdout = &b
+	c.Cmd.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// SetStdin sets the stdin of the command
+func (c ExecCmd) SetStdin(r io.Reader) {
+	c.Cmd.Stdin = r
+}
+
+// SetStdout sets the stdout of the command
+func (c ExecCmd) SetStdout(w io.Writer) {
+	c.Cmd.Stdout = w
+}
+
+// SetStderr sets the stderr of the command
+func (c ExecCmd) SetStderr(w io.Writer) {
+	c.Cmd.Stderr = w
+}
+
+// Exec returns the underlying *exec.Cmd
+func (c ExecCmd) Exec() *exec.Cmd {
+	return c.Cmd
+}
@@ -0,0 +1,26 @@
+package sandbox_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Merovius/bor/sandbox"
+	_ "github.com/Merovius/bor/sandbox/plain"
+)
+
+func TestWaitDetached(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The detached grandchild survives the kill and holds the output-pipes
+	cmd := sandbox.CommandContext(ctx, "plain", "sh", "-c", "setsid sleep 8 & sleep 100")
+	start := time.Now()
+	_, err := sandbox.CombinedOutput(cmd)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Wait returned after %v, want less than 2s", d)
+	}
+	if _, ok := err.(sandbox.TimeoutError); !ok {
+		t.Errorf("Wait returned %v, want a TimeoutError", err)
+	}
+}
@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// helpers contains the functions that can be run in a re-executed copy of
+// bor. Some sandboxes need to set up the environment of a process from within
+// (e.g. mounts), before executing the actual command.
+var helpers = make(map[string]func(args []string))
+
+// RegisterHelper registers f to be run, if bor is executed with name as
+// argv[0]. It is an error to register a name that is already taken. f gets
+// passed the remaining arguments and should not return, but replace the
+// process by the command (e.g. with syscall.Exec)
+func RegisterHelper(name string, f func(args []string)) error {
+	if _, exists := helpers[name]; exists {
+		return fmt.Errorf("Sandbox helper %s already registered", name)
+	}
+	helpers[name] = f
+	return nil
+}
+
+// HelperCommand returns an *exec.Cmd, that re-executes the running binary as
+// the helper name, passing it arg
+func HelperCommand(name string, arg ...string) *exec.Cmd {
+	cmd := exec.Command("/proc/self/exe", arg...)
+	cmd.Args[0] = name
+	return cmd
+}
+
+// RunHelper checks, whether the running process was started as a helper and
+// if so, runs it. If the helper returns, the process exits. It must be called
+// at the very start of main
+func RunHelper() {
+	f, ok := helpers[os.Args[0]]
+	if !ok {
+		return
+	}
+	f(os.Args[1:])
+	os.Exit(127)
+}
+
+// HelperFatal writes an error of the helper name to stderr and exits. As the
+// stderr of a helper is the stderr of the command it runs, the error ends up in
+// the output of the command
+func HelperFatal(name string, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+	os.Exit(127)
+}
@@ -0,0 +1,261 @@
+// Package landlock implements the sandbox.Driver interface, restricting the
+// filesystem access of commands with Landlock. The command can only read the
+// configured paths (usually the system toolchain and libraries) and read and
+// write the configured writable paths and its working directory (i.e. the
+// build-dir). This makes it possible to run make with only the access it
+// needs, without any privileges.
+//
+// Landlock needs Linux 5.13 or later. If it is not available, every command
+// fails. The restrictions are set up by re-executing bor as a helper, so
+// sandbox.RunHelper must be called at the start of main.
+package landlock
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	goconf "code.google.com/p/goconf/conf"
+	"github.com/Merovius/bor/sandbox"
+)
+
+const helper = "bor-landlock"
+
+// Constants from linux/landlock.h and linux/prctl.h, which are not defined in
+// package syscall
+const (
+	sysCreateRuleset = 444
+	sysAddRule       = 445
+	sysRestrictSelf  = 446
+
+	createRulesetVersion = 1 << 0
+	rulePathBeneath      = 1
+
+	accessExecute    = 1 << 0
+	accessWriteFile  = 1 << 1
+	accessReadFile   = 1 << 2
+	accessReadDir    = 1 << 3
+	accessRemoveDir  = 1 << 4
+	accessRemoveFile = 1 << 5
+	accessMakeChar   = 1 << 6
+	accessMakeDir    = 1 << 7
+	accessMakeReg    = 1 << 8
+	accessMakeSock   = 1 << 9
+	accessMakeFifo   = 1 << 10
+	accessMakeBlock  = 1 << 11
+	accessMakeSym    = 1 << 12
+	accessRefer      = 1 << 13 // Since ABI version 2
+	accessTruncate   = 1 << 14 // Since ABI version 3
+	accessIoctlDev   = 1 << 15 // Since ABI version 5
+
+	// The rights, that can be given for files (instead of directories)
+	accessFile = accessExecute | accessWriteFile | accessReadFile | accessTruncate | accessIoctlDev
+
+	accessRead = accessExecute | accessReadFile | accessReadDir
+
+	oPath           = 0x200000
+	prSetNoNewPrivs = 38
+)
+
+var (
+	readOnly = []string{
+		"/usr", "/lib", "/lib32", "/lib64", "/bin", "/sbin",
+		"/etc/ld.so.cache", "/etc/alternatives", "/etc/localtime",
+		"/dev/urandom",
+	}
+	readWrite = []string{"/dev/null", "/dev/zero"}
+)
+
+// Driver implements the sandbox-interface
+type Driver struct{}
+
+// Command returns a command, that runs name with restricted filesystem access,
+// by re-executing bor as a helper. The read-only and writable paths are passed
+// first, each preceded by their number
+func (d Driver) Command(name string, arg ...string) sandbox.Cmd {
+	args := []string{strconv.Itoa(len(readOnly))}
+	args = append(args, readOnly...)
+	args = append(args, strconv.Itoa(len(readWrite)))
+	args = append(args, readWrite...)
+	args = append(args, name)
+	args = append(args, arg...)
+	return sandbox.ExecCmd{Cmd: sandbox.HelperCommand(helper, args...)}
+}
+
+// Config configures the landlock package. ReadOnly and ReadWrite are
+// space-separated lists of paths, replacing the defaults
+func (d Driver) Config(cfg *goconf.ConfigFile) error {
+	if str, err := cfg.GetString("landlock", "ReadOnly"); err == nil {
+		readOnly = strings.Fields(str)
+	}
+	if str, err := cfg.GetString("landlock", "ReadWrite"); err == nil {
+		readWrite = strings.Fields(str)
+	}
+	return nil
+}
+
+// handled returns all access rights known to the Landlock ABI of the kernel
+func handled() (uint64, error) {
+	abi, _, errno := syscall.Syscall(sysCreateRuleset, 0, 0, createRulesetVersion)
+	if errno != 0 {
+		return 0, fmt.Errorf("Landlock not supported: %v", errno)
+	}
+
+	access := uint64(accessExecute | accessWriteFile | accessReadFile | accessReadDir |
+		accessRemoveDir | accessRemoveFile | accessMakeChar | accessMakeDir |
+		accessMakeReg | accessMakeSock | accessMakeFifo | accessMakeBlock |
+		accessMakeSym | accessRefer | accessTruncate | accessIoctlDev)
+	if abi < 2 {
+		access &^= accessRefer
+	}
+	if abi < 3 {
+		access &^= accessTruncate
+	}
+	if abi < 5 {
+		access &^= accessIoctlDev
+	}
+	return access, nil
+}
+
+// pathBeneathAttr is struct landlock_path_beneath_attr. The kernel struct is
+// packed, but as the fields are in the same place, the padding does not
+// matter
+type pathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// addRule allows access to everything beneath path. Paths that don't exist
+// are ignored
+func addRule(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", path, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %s: %v", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= accessFile
+	}
+
+	attr := pathBeneathAttr{access, int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysAddRule, uintptr(ruleset), rulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("adding rule for %s: %v", path, errno)
+	}
+	return nil
+}
+
+// restrict restricts the filesystem access of the process to the given paths
+func restrict(ro, rw []string) error {
+	access, err := handled()
+	if err != nil {
+		return err
+	}
+
+	attr := access
+	fd, _, errno := syscall.Syscall(sysCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating ruleset: %v", errno)
+	}
+	ruleset := int(fd)
+	defer syscall.Close(ruleset)
+
+	for _, p := range ro {
+		if err := addRule(ruleset, p, access&accessRead); err != nil {
+			return err
+		}
+	}
+	for _, p := range rw {
+		if err := addRule(ruleset, p, access); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %v", errno)
+	}
+	if _, _, errno := syscall.Syscall(sysRestrictSelf, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("restricting: %v", errno)
+	}
+	return nil
+}
+
+// paths reads a list of paths from args, preceded by their number, and returns
+// it and the remaining arguments
+func paths(args []string) ([]string, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("not enough arguments")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) < n+1 {
+		return nil, nil, fmt.Errorf("not enough arguments")
+	}
+	return args[1 : n+1 : n+1], args[n+1:], nil
+}
+
+// run is the helper, restricting the filesystem access and executing the
+// command. args are the read-only and writable paths, each preceded by their
+// number, the command and its arguments
+func run(args []string) {
+	ro, args, err := paths(args)
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+	rw, args, err := paths(args)
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+	if len(args) == 0 {
+		sandbox.HelperFatal(helper, fmt.Errorf("not enough arguments"))
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+	rw = append(rw, wd)
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+
+	// Compilers need a writable directory for temporary files
+	os.Setenv("TMPDIR", wd)
+
+	// Landlock restricts only the calling thread and its future children,
+	// so everything happens in the thread executing the command
+	runtime.LockOSThread()
+	if err := restrict(ro, rw); err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+
+	err = syscall.Exec(path, args, os.Environ())
+	sandbox.HelperFatal(helper, err)
+}
+
+// init registers the landlock driver and its helper
+func init() {
+	sandbox.Register("landlock", Driver{})
+	sandbox.RegisterHelper(helper, run)
+}
@@ -3,8 +3,10 @@ package sandbox
 import (
 	"bytes"
 	goconf "code.google.com/p/goconf/conf"
+	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -33,10 +35,11 @@ type Cmd interface {
 
 	Dir() string
 	SetDir(string)
+	SetStdin(io.Reader)
 	SetStdout(io.Writer)
 	SetStderr(io.Writer)
 
-	ProcessState() ProcessState
+	ProcessState() ProcessState // nil, if the command has not run
 }
 
 // ProcessState is basically a wrapper around *os.ProcessState. We can not use
@@ -44,6 +47,7 @@ type Cmd interface {
 // (for example using VMs for sandboxing)
 type ProcessState interface {
 	Exited() bool
+	ExitCode() int // -1, if the process has not exited or was killed by a signal
 	Pid() int
 	String() string
 	Success() bool
@@ -55,18 +59,23 @@ type ProcessState interface {
 // that is already taken
 func Register(name string, driver Driver) error {
 	if _, exists := drivers[name]; exists {
-		return fmt.Errorf("Sandbox driver %s already registered")
+		return fmt.Errorf("Sandbox driver %s already registered", name)
 	}
 	drivers[name] = driver
 	return nil
 }
 
-// Command wraps the Command-method of the given driver
+// Command wraps the Command-method of the given driver. If limits are
+// configured for the driver, the command is run with them
 func Command(driver string, name string, arg ...string) Cmd {
-	if dr, ok := drivers[driver]; ok {
-		return dr.Command(name, arg...)
-	}
-	panic(fmt.Errorf("No such Sandbox driver: %s", driver))
+	return CommandLimits(context.Background(), driver, Limits{}, name, arg...)
+}
+
+// CommandContext works like Command, but the command is killed, once ctx is
+// done. Wait then returns a TimeoutError, if the deadline of ctx was exceeded,
+// and a CanceledError otherwise
+func CommandContext(ctx context.Context, driver string, name string, arg ...string) Cmd {
+	return CommandLimits(ctx, driver, Limits{}, name, arg...)
 }
 
 // Config calls the Config-method of all registered drivers
@@ -74,12 +83,24 @@ func Config(cfg *goconf.ConfigFile) error {
     if num, err := cfg.GetInt("default", "BufferSize"); err == nil {
 		bufsize = num
     }
+	if str, err := cfg.GetString("cgroup", "Root"); err == nil {
+		cgroupRoot = str
+	}
 	for _, dr := range drivers {
 		err := dr.Config(cfg)
 		if err != nil {
 			return err
 		}
 	}
+	// Drivers can register further drivers in Config, so we read the limits
+	// separately
+	for name := range drivers {
+		l, err := configLimits(cfg, name)
+		if err != nil {
+			return err
+		}
+		limits[name] = l
+	}
 	return nil
 }
 
@@ -91,33 +112,144 @@ func (e TimeoutError) Error() string {
 	return "Timeout"
 }
 
-// TimeoutCombinedOutput works like exec.Cmd.CombinedOutput(), except a timeout
-// is given, after which the Process is automatically killed
-func TimeoutCombinedOutput(cmd Cmd, timeout time.Duration) ([]byte, error) {
-	// We need to buffer the output
-	outbuf := bytes.NewBuffer(make([]byte, 0, bufsize))
+// CanceledError represents an error due to the cancellation of the context of
+// a command, e.g. because the client disconnected
+type CanceledError struct{}
 
-	cmd.SetStdout(outbuf)
-	cmd.SetStderr(outbuf)
+// Error returns the string "Canceled"
+func (e CanceledError) Error() string {
+	return "Canceled"
+}
 
-	err := cmd.Start()
-	if err != nil {
-		return nil, err
+// contextError converts the error of a context to a TimeoutError or a
+// CanceledError
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return TimeoutError{}
 	}
+	return CanceledError{}
+}
+
+// exitWaiter is implemented by commands, that can wait for their process to
+// exit without reaping it. Until it is reaped, its PID and process group can
+// not be reused, so it can still be killed safely. awaitExit returns an error,
+// if this is not possible
+type exitWaiter interface {
+	awaitExit() error
+}
+
+// ctxCmd wraps a Cmd and kills it, when its context is done
+type ctxCmd struct {
+	Cmd
+	ctx  context.Context
+	done chan struct{}
 
-	to := time.After(timeout)
-	ch := make(chan error)
+	// The command is only killed, until its exit is observed by Wait
+	mu     sync.Mutex
+	exited bool
+	killed bool
+}
 
+// Start starts the command and kills it, once the context is done
+func (c *ctxCmd) Start() error {
+	if err := c.ctx.Err(); err != nil {
+		return contextError(err)
+	}
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+
+	c.done = make(chan struct{})
 	go func() {
-		err := cmd.Wait()
-		ch <- err
+		select {
+		case <-c.ctx.Done():
+			c.mu.Lock()
+			if !c.exited {
+				c.Cmd.Kill()
+				c.killed = true
+			}
+			c.mu.Unlock()
+		case <-c.done:
+		}
 	}()
+	return nil
+}
+
+// setExited marks the command as exited, so it is not killed anymore, and
+// returns whether it was killed before
+func (c *ctxCmd) setExited() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exited = true
+	return c.killed
+}
+
+// Wait waits for the command to exit. If it was killed because the context is
+// done, a TimeoutError or CanceledError is returned
+func (c *ctxCmd) Wait() error {
+	if c.done == nil {
+		return c.Cmd.Wait()
+	}
+	defer close(c.done)
+
+	// If possible, we observe the exit before the command is reaped by
+	// Wait. Otherwise, it could be killed after that, when its PID may
+	// already be reused, and would be reported as killed, even though it
+	// finished
+	var killed bool
+	ew, ok := c.Cmd.(exitWaiter)
+	ok = ok && ew.awaitExit() == nil
+	if ok {
+		killed = c.setExited()
+	}
+	err := c.Cmd.Wait()
+	if !ok {
+		killed = c.setExited()
+	}
+	if killed {
+		return contextError(c.ctx.Err())
+	}
+	return err
+}
+
+// Run starts the command and waits for it to exit
+func (c *ctxCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its stdout
+func (c *ctxCmd) Output() ([]byte, error) {
+	var b bytes.Buffer
+	c.SetStdout(&b)
+	err := c.Run()
+	return b.Bytes(), err
+}
 
-	select {
-	case <-to:
-		cmd.Kill()
-		return outbuf.Bytes(), TimeoutError{}
-	case err = <-ch:
-		return outbuf.Bytes(), err
+// CombinedOutput runs the command and returns its stdout and stderr
+func (c *ctxCmd) CombinedOutput() ([]byte, error) {
+	var b bytes.Buffer
+	c.SetStdout(&b)
+	c.SetStderr(&b)
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// CombinedOutput runs cmd and returns its combined stdout and stderr. Unlike
+// the method of the same name, the output is buffered with the configured
+// BufferSize. Timeouts and cancellation are given by the context of the
+// command
+func CombinedOutput(cmd Cmd) ([]byte, error) {
+	outbuf := bytes.NewBuffer(make([]byte, 0, bufsize))
+
+	cmd.SetStdout(outbuf)
+	cmd.SetStderr(outbuf)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
 	}
+	err := cmd.Wait()
+	return outbuf.Bytes(), err
 }
@@ -0,0 +1,241 @@
+// Package namespaces implements the sandbox.Driver interface, running commands
+// in fresh user, PID, mount, network, IPC and UTS namespaces. The root
+// filesystem is read-only in the sandbox, only the working directory of the
+// command (i.e. the build-dir) is writable. There is no network access except
+// an unconfigured loopback device.
+//
+// The command runs as root in its user namespace, which is mapped to the user
+// running bor. All capabilities are dropped before the command is executed, so
+// it can not undo the restrictions.
+//
+// The namespaces are set up by re-executing bor as a helper, so
+// sandbox.RunHelper must be called at the start of main. Unprivileged user
+// namespaces need to be enabled in the kernel.
+package namespaces
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	goconf "code.google.com/p/goconf/conf"
+	"github.com/Merovius/bor/sandbox"
+)
+
+const helper = "bor-namespaces"
+
+// Some constants from linux/prctl.h and linux/securebits.h, which are not
+// defined in package syscall
+const (
+	prSetNoNewPrivs = 38
+	prCapbsetDrop   = 24
+	prSetSecurebits = 28
+
+	// SECBIT_NOROOT, SECBIT_NO_SETUID_FIXUP, SECBIT_KEEP_CAPS and their
+	// locks, except for SECBIT_KEEP_CAPS itself
+	securebits = 0x01 | 0x02 | 0x04 | 0x08 | 0x20
+)
+
+var (
+	hostname = "bor"
+)
+
+// Driver implements the sandbox-interface
+type Driver struct{}
+
+// Command returns a command, that runs name in new namespaces, by
+// re-executing bor as a helper
+func (d Driver) Command(name string, arg ...string) sandbox.Cmd {
+	cmd := sandbox.HelperCommand(helper, append([]string{hostname, name}, arg...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	return sandbox.ExecCmd{Cmd: cmd}
+}
+
+// Config configures the namespaces package. The only option is the hostname
+// to use in the sandbox
+func (d Driver) Config(cfg *goconf.ConfigFile) error {
+	if str, err := cfg.GetString("namespaces", "Hostname"); err == nil {
+		hostname = str
+	}
+	return nil
+}
+
+// mountFlags maps the options in /proc/self/mountinfo to the flags that need to
+// be preserved on a remount
+var mountFlags = map[string]uintptr{
+	"nosuid":     syscall.MS_NOSUID,
+	"nodev":      syscall.MS_NODEV,
+	"noexec":     syscall.MS_NOEXEC,
+	"noatime":    syscall.MS_NOATIME,
+	"nodiratime": syscall.MS_NODIRATIME,
+	"relatime":   syscall.MS_RELATIME,
+}
+
+// mount is a mount point, as given in /proc/self/mountinfo
+type mount struct {
+	dir   string
+	flags uintptr
+	ro    bool
+}
+
+// mounts returns all mount points of the process
+func mounts() ([]mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ms []mount
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		// Special characters in the mount point are octal escaped
+		dir, err := strconv.Unquote(`"` + fields[4] + `"`)
+		if err != nil {
+			dir = fields[4]
+		}
+		m := mount{dir: dir}
+		for _, o := range strings.Split(fields[5], ",") {
+			m.flags |= mountFlags[o]
+			m.ro = m.ro || o == "ro"
+		}
+		ms = append(ms, m)
+	}
+	return ms, s.Err()
+}
+
+// below returns whether path is dir or below it
+func below(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// setup sets up the namespaces from the inside: Every mount point except the
+// working directory is made read-only, /proc is remounted for the new PID
+// namespace and the hostname is set
+func setup(host string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// Make sure, none of our mounts propagate to the parent namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %v", err)
+	}
+
+	// Bind-mount the working directory to itself, so it is a mount point of
+	// its own and stays writable
+	if err := syscall.Mount(wd, wd, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("binding %s: %v", wd, err)
+	}
+
+	ms, err := mounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		if below(m.dir, wd) || below(m.dir, "/proc") {
+			continue
+		}
+		if m.ro {
+			continue
+		}
+		err := syscall.Mount("", m.dir, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|m.flags, "")
+		// A mount point hidden by a later mount is not reachable anyway.
+		// Every other mount has to be read-only, or the sandbox could write
+		// to it
+		if err == syscall.ENOENT {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("remounting %s read-only: %v", m.dir, err)
+		}
+	}
+
+	// Mount a new proc, so only processes in the sandbox are visible. Without
+	// it, the host's processes could be seen (and signaled)
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mounting /proc: %v", err)
+	}
+
+	if err := syscall.Sethostname([]byte(host)); err != nil {
+		return fmt.Errorf("setting hostname: %v", err)
+	}
+
+	// Compilers need a writable directory for temporary files
+	os.Setenv("TMPDIR", wd)
+
+	return nil
+}
+
+// dropCapabilities makes sure, the executed command has no capabilities in
+// its user namespace, even though it runs as root
+func dropCapabilities() error {
+	last := 63
+	if b, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			last = n
+		}
+	}
+	for c := 0; c <= last; c++ {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(c), 0); errno != 0 {
+			return fmt.Errorf("dropping capability %d: %v", c, errno)
+		}
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSecurebits, securebits, 0); errno != 0 {
+		return fmt.Errorf("setting securebits: %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %v", errno)
+	}
+	return nil
+}
+
+// run is the helper, running in the new namespaces. args are the hostname, the
+// command and its arguments
+func run(args []string) {
+	if len(args) < 2 {
+		sandbox.HelperFatal(helper, fmt.Errorf("not enough arguments"))
+	}
+
+	if err := setup(args[0]); err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+
+	path, err := exec.LookPath(args[1])
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+
+	if err := dropCapabilities(); err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+
+	err = syscall.Exec(path, args[1:], os.Environ())
+	sandbox.HelperFatal(helper, err)
+}
+
+// init registers the namespaces driver and its helper
+func init() {
+	sandbox.Register("namespaces", Driver{})
+	sandbox.RegisterHelper(helper, run)
+}
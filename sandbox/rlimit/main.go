@@ -0,0 +1,121 @@
+// Package rlimit implements the sandbox.Driver interface, limiting the
+// resources of commands with setrlimit(2). Unlike cgroups, this needs neither
+// root nor any delegation, but the limits apply to each process separately
+// (except for NPROC, which counts all processes of the user running bor).
+//
+// The limits are set by re-executing bor as a helper, so sandbox.RunHelper
+// must be called at the start of main.
+package rlimit
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	goconf "code.google.com/p/goconf/conf"
+	"github.com/Merovius/bor/sandbox"
+)
+
+const helper = "bor-rlimit"
+
+// rlimitNproc is RLIMIT_NPROC from sys/resource.h, which is not defined in
+// package syscall
+const rlimitNproc = 6
+
+// limit is a resource limited by the driver. A value < 0 means, the limit of
+// bor is inherited
+type limit struct {
+	name     string
+	resource int
+	value    int64
+}
+
+var limits = []*limit{
+	{"AS", syscall.RLIMIT_AS, -1},
+	{"CPU", syscall.RLIMIT_CPU, -1},
+	{"NPROC", rlimitNproc, -1},
+	{"FSIZE", syscall.RLIMIT_FSIZE, -1},
+	{"NOFILE", syscall.RLIMIT_NOFILE, -1},
+	{"CORE", syscall.RLIMIT_CORE, 0},
+}
+
+// Driver implements the sandbox-interface
+type Driver struct{}
+
+// Command returns a command, that runs name with the configured limits, by
+// re-executing bor as a helper. The values of all limits are passed first
+func (d Driver) Command(name string, arg ...string) sandbox.Cmd {
+	var args []string
+	for _, l := range limits {
+		args = append(args, strconv.FormatInt(l.value, 10))
+	}
+	args = append(args, name)
+	args = append(args, arg...)
+	return sandbox.ExecCmd{Cmd: sandbox.HelperCommand(helper, args...)}
+}
+
+// Config configures the rlimit package. The limits are given in the section
+// rlimit, named like the resources without the RLIMIT_-prefix. CPU is in
+// seconds, AS and FSIZE in bytes
+func (d Driver) Config(cfg *goconf.ConfigFile) error {
+	for _, l := range limits {
+		str, err := cfg.GetString("rlimit", l.name)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid value for rlimit %s: %s", l.name, str)
+		}
+		l.value = n
+	}
+	return nil
+}
+
+// run is the helper, setting the limits and executing the command. args are
+// the values of all limits, the command and its arguments
+func run(args []string) {
+	if len(args) < len(limits)+1 {
+		sandbox.HelperFatal(helper, fmt.Errorf("not enough arguments"))
+	}
+
+	path, err := exec.LookPath(args[len(limits)])
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		sandbox.HelperFatal(helper, err)
+	}
+
+	for i, l := range limits {
+		v, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			sandbox.HelperFatal(helper, err)
+		}
+		if v < 0 {
+			continue
+		}
+		rl := syscall.Rlimit{Cur: uint64(v), Max: uint64(v)}
+		// For CPU, the soft limit sends SIGXCPU, the hard limit SIGKILL.
+		// We give the process a second to notice
+		if l.resource == syscall.RLIMIT_CPU {
+			rl.Max++
+		}
+		if err := syscall.Setrlimit(l.resource, &rl); err != nil {
+			sandbox.HelperFatal(helper, fmt.Errorf("setting %s: %v", l.name, err))
+		}
+	}
+
+	err = syscall.Exec(path, args[len(limits):], os.Environ())
+	sandbox.HelperFatal(helper, err)
+}
+
+// init registers the rlimit driver and its helper
+func init() {
+	sandbox.Register("rlimit", Driver{})
+	sandbox.RegisterHelper(helper, run)
+}
@@ -0,0 +1,118 @@
+package seccomp
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Some constants from linux/seccomp.h and linux/prctl.h, which are not defined
+// in package syscall
+const (
+	prSetNoNewPrivs   = 38
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+
+	retKillProcess = 0x80000000
+	retTrace       = 0x7ff00000
+	retAllow       = 0x7fff0000
+
+	// Offsets in struct seccomp_data. dataArgs is the lower half of the
+	// first argument on little-endian architectures
+	dataNr   = 0
+	dataArch = 4
+	dataArgs = 16
+
+	// Flags of open, that allow writing to (or creating) a file
+	writeFlags = syscall.O_ACCMODE | syscall.O_CREAT | syscall.O_TRUNC
+
+	// Syscalls of the x32 ABI have this bit set in their number
+	x32Bit = 0x40000000
+)
+
+// always contains the syscalls, that are allowed in every profile, because
+// they are needed to execute the command or to exit
+var always = []string{"execve", "exit", "exit_group", "rt_sigreturn"}
+
+func stmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// readOnly maps the syscalls opening files to the index of their flags
+// argument
+var readOnly = map[string]uint32{"open": 1, "openat": 2}
+
+// program assembles a BPF program, allowing or denying the given syscalls.
+// All other syscalls are allowed, if allowDefault is true, and denied
+// otherwise. If a syscall is allowed and denied, it is denied. Unless write is
+// true, opening a file for writing is denied as well. Denied syscalls return
+// SECCOMP_RET_TRACE, so the tracer can report them
+func program(allowDefault, write bool, allow, deny []int) []syscall.SockFilter {
+	prog := []syscall.SockFilter{
+		// Kill processes using another architecture, as syscall numbers
+		// differ between them
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, dataArch),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, auditArch, 1, 0),
+		stmt(syscall.BPF_RET|syscall.BPF_K, retKillProcess),
+
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, dataNr),
+		jump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, x32Bit, 0, 1),
+		stmt(syscall.BPF_RET|syscall.BPF_K, retTrace),
+	}
+
+	add := func(nrs []int, action uint32) {
+		for _, nr := range nrs {
+			prog = append(prog,
+				jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(nr), 0, 1),
+				stmt(syscall.BPF_RET|syscall.BPF_K, action))
+		}
+	}
+
+	var alw []int
+	for _, name := range always {
+		if nr, ok := syscalls[name]; ok {
+			alw = append(alw, nr)
+		}
+	}
+	add(alw, retAllow)
+	add(deny, retTrace)
+	if !write {
+		for name, arg := range readOnly {
+			nr, ok := syscalls[name]
+			if !ok {
+				continue
+			}
+			prog = append(prog,
+				jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(nr), 0, 4),
+				stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, dataArgs+8*arg),
+				jump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, writeFlags, 0, 1),
+				stmt(syscall.BPF_RET|syscall.BPF_K, retTrace),
+				// Restore the syscall number for the checks below
+				stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, dataNr))
+		}
+	}
+	add(allow, retAllow)
+
+	if allowDefault {
+		prog = append(prog, stmt(syscall.BPF_RET|syscall.BPF_K, retAllow))
+	} else {
+		prog = append(prog, stmt(syscall.BPF_RET|syscall.BPF_K, retTrace))
+	}
+	return prog
+}
+
+// install installs prog as a seccomp filter for the calling thread. It also
+// sets no_new_privs, which is needed to install a filter without privileges
+func install(prog []syscall.SockFilter) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return errno
+	}
+	fprog := syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return errno
+	}
+	return nil
+}
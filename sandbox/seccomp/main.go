@@ -0,0 +1,472 @@
+// Package seccomp implements the sandbox.Driver interface, restricting the
+// syscalls a command can do with a seccomp-bpf filter. Unlike EasySandbox, the
+// filter is configurable, so multithreaded programs or programs reading files
+// can be run.
+//
+// Which syscalls are allowed is given by named profiles. The builtin profiles
+// are "compute", "threads" and "fileread", more can be defined in the config.
+// The driver "seccomp" uses the profile given by the Profile option, every
+// profile is also available as a driver named "seccomp-<profile>".
+//
+// If a command does a forbidden syscall, it is killed and Wait returns a
+// SyscallError naming the syscall. For this, the command is run under a tracer,
+// by re-executing bor as a helper, so sandbox.RunHelper must be called at the
+// start of main. Like in a shell, if the command is killed by a signal other
+// than SIGKILL, its exit status is 128 plus the signal number.
+package seccomp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	goconf "code.google.com/p/goconf/conf"
+	"github.com/Merovius/bor/sandbox"
+)
+
+const (
+	tracer = "bor-seccomp"
+	filter = "bor-seccomp-filter"
+
+	// Constants for ptrace, which are not defined in package syscall
+	ptraceOTraceseccomp = 0x80
+	ptraceOExitkill     = 0x100000
+	ptraceEventSeccomp  = 7
+	ptraceOptions       = ptraceOTraceseccomp | syscall.PTRACE_O_TRACEFORK |
+		syscall.PTRACE_O_TRACEVFORK | syscall.PTRACE_O_TRACECLONE | ptraceOExitkill
+)
+
+// profile is a policy of allowed and forbidden syscalls
+type profile struct {
+	Include string   // The name of a profile this profile extends
+	Default string   // What to do with syscalls that are not listed: "allow" or "deny"
+	Allow   []string // Syscalls to allow
+	Deny    []string // Syscalls to deny
+	Write   bool     // Whether open and openat may open files for writing, also if an included profile sets it
+}
+
+// compute contains everything a dynamically linked, single-threaded program
+// needs, to be loaded and do its computation with stdin/stdout. The dynamic
+// loader needs to open the shared libraries, so opening files can not be
+// forbidden. Unless a profile sets Write, they can only be opened read-only,
+// though.
+var compute = []string{
+	"read", "write", "readv", "writev", "pread64", "close", "fstat",
+	"newfstatat", "stat", "lstat", "statx", "lseek", "ioctl", "access",
+	"faccessat", "faccessat2", "open", "openat", "readlink",
+	"mmap", "mprotect", "munmap", "mremap", "brk", "madvise",
+	"rt_sigaction", "rt_sigprocmask", "sigaltstack", "arch_prctl",
+	"set_tid_address", "set_robust_list", "rseq", "prlimit64", "getrandom",
+	"futex", "clock_gettime", "clock_getres", "gettimeofday", "time",
+	"nanosleep", "clock_nanosleep", "getpid", "gettid", "tgkill", "uname",
+}
+
+var (
+	profiles = map[string]*profile{
+		"compute": {Default: "deny", Allow: compute},
+		"threads": {Include: "compute", Default: "deny", Allow: []string{
+			"clone", "clone3", "sched_yield", "sched_getaffinity",
+			"sched_setaffinity", "membarrier", "get_robust_list",
+		}},
+		"fileread": {Include: "compute", Default: "deny", Allow: []string{
+			"getdents", "getdents64", "readlinkat", "fadvise64", "statfs",
+			"fstatfs", "getcwd", "dup", "dup2", "dup3", "fcntl",
+		}},
+	}
+	defaultProfile = "compute"
+)
+
+// resolve returns the default action, whether files may be opened for writing
+// and the allowed and denied syscalls of the profile name, including those of
+// all included profiles. Only the default action is not inherited
+func resolve(name string, depth int) (allowDefault, write bool, allow, deny []string, err error) {
+	if depth > len(profiles) {
+		return false, false, nil, nil, fmt.Errorf("Include-loop in seccomp profile %s", name)
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return false, false, nil, nil, fmt.Errorf("No such seccomp profile: %s", name)
+	}
+	if p.Include != "" {
+		_, write, allow, deny, err = resolve(p.Include, depth+1)
+		if err != nil {
+			return false, false, nil, nil, err
+		}
+	}
+	switch p.Default {
+	case "allow":
+		allowDefault = true
+	case "deny", "":
+	default:
+		return false, false, nil, nil, fmt.Errorf("Invalid default in seccomp profile %s: %s", name, p.Default)
+	}
+	allow = append(allow, p.Allow...)
+	deny = append(deny, p.Deny...)
+	return allowDefault, write || p.Write, allow, deny, nil
+}
+
+// numbers converts the names of syscalls to a comma-separated list of numbers
+func numbers(names []string) (string, error) {
+	var nrs []string
+	for _, n := range names {
+		nr, ok := syscalls[n]
+		if !ok {
+			return "", fmt.Errorf("Unknown syscall: %s", n)
+		}
+		nrs = append(nrs, strconv.Itoa(nr))
+	}
+	return strings.Join(nrs, ","), nil
+}
+
+// SyscallError is returned by Wait, if the command was killed because of a
+// forbidden syscall. It contains the name of the syscall
+type SyscallError string
+
+// Error implements the builtin error interface
+func (err SyscallError) Error() string {
+	return "Forbidden syscall: " + string(err)
+}
+
+// Cmd runs a command under the tracer
+type Cmd struct {
+	sandbox.ExecCmd
+	err    error    // An error that occurred when creating the command
+	report *os.File // The tracer writes the name of forbidden syscalls to this pipe
+}
+
+// Start starts the tracer, passing it a pipe to report forbidden syscalls
+func (c *Cmd) Start() error {
+	if c.err != nil {
+		return c.err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	c.Cmd.ExtraFiles = []*os.File{w}
+	err = c.ExecCmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return err
+	}
+	c.report = r
+	return nil
+}
+
+// Wait waits for the command to exit. If it was killed because of a forbidden
+// syscall, a SyscallError is returned
+func (c *Cmd) Wait() error {
+	err := c.ExecCmd.Wait()
+	if c.report == nil {
+		return err
+	}
+	b, _ := ioutil.ReadAll(c.report)
+	c.report.Close()
+	if len(b) > 0 {
+		return SyscallError(b)
+	}
+	return err
+}
+
+// Run starts the command and waits for it to exit
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its stdout
+func (c *Cmd) Output() ([]byte, error) {
+	var b bytes.Buffer
+	c.Cmd.Stdout = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its stdout and stderr
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	var b bytes.Buffer
+	c.Cmd.Stdout = &b
+	c.Cmd.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// Driver implements the sandbox-interface for a profile. The empty profile
+// means the configured default profile
+type Driver struct {
+	profile string
+}
+
+// Command returns a command, running name under the tracer with the profile
+// of the driver
+func (d Driver) Command(name string, arg ...string) sandbox.Cmd {
+	p := d.profile
+	if p == "" {
+		p = defaultProfile
+	}
+
+	c := &Cmd{}
+	allowDefault, write, allow, deny, err := resolve(p, 0)
+	var as, ds string
+	if err == nil {
+		as, err = numbers(allow)
+	}
+	if err == nil {
+		ds, err = numbers(deny)
+	}
+	c.err = err
+
+	args := append([]string{strconv.FormatBool(allowDefault), strconv.FormatBool(write), as, ds, name}, arg...)
+	c.ExecCmd = sandbox.ExecCmd{Cmd: sandbox.HelperCommand(tracer, args...)}
+	return c
+}
+
+// Config configures the seccomp package. It reads the default profile and all
+// profiles given in sections named "seccomp-<profile>"
+func (d Driver) Config(cfg *goconf.ConfigFile) error {
+	// All profiles are configured by the default driver
+	if d.profile != "" {
+		return nil
+	}
+
+	if str, err := cfg.GetString("seccomp", "Profile"); err == nil {
+		defaultProfile = str
+	}
+
+	for _, sec := range cfg.GetSections() {
+		if !strings.HasPrefix(sec, "seccomp-") {
+			continue
+		}
+		name := strings.TrimPrefix(sec, "seccomp-")
+		p := &profile{}
+		if str, err := cfg.GetString(sec, "Include"); err == nil {
+			p.Include = str
+		}
+		if str, err := cfg.GetString(sec, "Default"); err == nil {
+			p.Default = str
+		}
+		if str, err := cfg.GetString(sec, "Allow"); err == nil {
+			p.Allow = strings.Fields(strings.Replace(str, ",", " ", -1))
+		}
+		if str, err := cfg.GetString(sec, "Deny"); err == nil {
+			p.Deny = strings.Fields(strings.Replace(str, ",", " ", -1))
+		}
+		if b, err := cfg.GetBool(sec, "Write"); err == nil {
+			p.Write = b
+		}
+		if _, exists := profiles[name]; !exists {
+			if err := sandbox.Register(sec, Driver{name}); err != nil {
+				return err
+			}
+		}
+		profiles[name] = p
+	}
+
+	// Check all profiles now, so errors are reported at startup. If we don't
+	// know the syscalls of this architecture, the driver can not be used at
+	// all, so we don't complain
+	if len(syscalls) == 0 {
+		return nil
+	}
+	for name := range profiles {
+		_, _, allow, deny, err := resolve(name, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := numbers(append(allow, deny...)); err != nil {
+			return fmt.Errorf("seccomp profile %s: %v", name, err)
+		}
+	}
+	if _, ok := profiles[defaultProfile]; !ok {
+		return fmt.Errorf("No such seccomp profile: %s", defaultProfile)
+	}
+	return nil
+}
+
+// syscallName returns the name of the syscall with number nr
+func syscallName(nr int) string {
+	for name, n := range syscalls {
+		if n == nr {
+			return name
+		}
+	}
+	return fmt.Sprintf("syscall %d", nr)
+}
+
+// exit exits the tracer with the status of the command. If the command was
+// killed by a signal other than SIGKILL, the exit status is 128+signal
+func exit(ws syscall.WaitStatus) {
+	if ws.Signaled() {
+		if ws.Signal() == syscall.SIGKILL {
+			syscall.Kill(os.Getpid(), syscall.SIGKILL)
+		}
+		os.Exit(128 + int(ws.Signal()))
+	}
+	os.Exit(ws.ExitStatus())
+}
+
+// trace is the tracer helper. It starts the filter helper as a tracee and
+// waits for it and all its descendants. If one of them does a forbidden
+// syscall, the name of the syscall is written to file descriptor 3 and the
+// command is killed. args are passed to the filter helper
+func trace(args []string) {
+	// ptrace needs all requests to come from the same thread
+	runtime.LockOSThread()
+
+	report := os.NewFile(3, "report")
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Args[0] = filter
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+	if err := cmd.Start(); err != nil {
+		sandbox.HelperFatal(tracer, err)
+	}
+	pid := cmd.Process.Pid
+
+	// The tracee stops at its first exec
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil); err != nil {
+		sandbox.HelperFatal(tracer, err)
+	}
+	if !ws.Stopped() {
+		exit(ws)
+	}
+	if err := syscall.PtraceSetOptions(pid, ptraceOptions); err != nil {
+		cmd.Process.Kill()
+		sandbox.HelperFatal(tracer, err)
+	}
+	if err := syscall.PtraceCont(pid, 0); err != nil {
+		cmd.Process.Kill()
+		sandbox.HelperFatal(tracer, err)
+	}
+
+	for {
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			cmd.Process.Kill()
+			sandbox.HelperFatal(tracer, err)
+		}
+
+		if ws.Exited() || ws.Signaled() {
+			// Remaining descendants are killed by the kernel, when we
+			// exit (PTRACE_O_EXITKILL)
+			if wpid == pid {
+				exit(ws)
+			}
+			continue
+		}
+		if !ws.Stopped() {
+			continue
+		}
+
+		sig := ws.StopSignal()
+		if sig == syscall.SIGTRAP && ws.TrapCause() == ptraceEventSeccomp {
+			name := "unknown syscall"
+			var regs syscall.PtraceRegs
+			if syscall.PtraceGetRegs(wpid, &regs) == nil {
+				name = syscallName(syscallNumber(&regs))
+			}
+			fmt.Fprint(report, name)
+			syscall.Kill(wpid, syscall.SIGKILL)
+			syscall.Kill(os.Getpid(), syscall.SIGKILL)
+		}
+
+		// SIGTRAP is used for the other ptrace-events, SIGSTOP for the
+		// initial stop of new tracees. Everything else is a real signal,
+		// that needs to be delivered
+		if sig == syscall.SIGTRAP || sig == syscall.SIGSTOP {
+			sig = 0
+		}
+		syscall.PtraceCont(wpid, int(sig))
+	}
+}
+
+// parseNumbers parses a comma-separated list of syscall numbers
+func parseNumbers(s string) ([]int, error) {
+	var nrs []int
+	for _, f := range strings.Split(s, ",") {
+		if f == "" {
+			continue
+		}
+		nr, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		nrs = append(nrs, nr)
+	}
+	return nrs, nil
+}
+
+// run is the filter helper. It installs the seccomp filter and executes the
+// command. args are whether to allow syscalls by default, whether to allow
+// opening files for writing, the allowed and denied syscall numbers, the
+// command and its arguments
+func run(args []string) {
+	if len(args) < 5 {
+		sandbox.HelperFatal(filter, fmt.Errorf("not enough arguments"))
+	}
+
+	// The filter is installed for the current thread only, which then
+	// executes the command
+	runtime.LockOSThread()
+
+	allowDefault, err := strconv.ParseBool(args[0])
+	if err != nil {
+		sandbox.HelperFatal(filter, err)
+	}
+	write, err := strconv.ParseBool(args[1])
+	if err != nil {
+		sandbox.HelperFatal(filter, err)
+	}
+	allow, err := parseNumbers(args[2])
+	if err != nil {
+		sandbox.HelperFatal(filter, err)
+	}
+	deny, err := parseNumbers(args[3])
+	if err != nil {
+		sandbox.HelperFatal(filter, err)
+	}
+
+	path, err := exec.LookPath(args[4])
+	if err != nil {
+		sandbox.HelperFatal(filter, err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		sandbox.HelperFatal(filter, err)
+	}
+
+	if err := install(program(allowDefault, write, allow, deny)); err != nil {
+		sandbox.HelperFatal(filter, err)
+	}
+
+	err = syscall.Exec(path, args[4:], os.Environ())
+	sandbox.HelperFatal(filter, err)
+}
+
+// init registers the seccomp drivers for all builtin profiles and the helpers
+func init() {
+	sandbox.Register("seccomp", Driver{})
+	for name := range profiles {
+		sandbox.Register("seccomp-"+name, Driver{name})
+	}
+	sandbox.RegisterHelper(tracer, trace)
+	sandbox.RegisterHelper(filter, run)
+}
@@ -0,0 +1,30 @@
+package seccomp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	old := profiles
+	defer func() { profiles = old }()
+	profiles = map[string]*profile{
+		"base":  {Default: "deny", Allow: []string{"read"}, Write: true},
+		"child": {Include: "base", Default: "allow", Deny: []string{"open"}},
+		"loop":  {Include: "loop"},
+	}
+
+	allowDefault, write, allow, deny, err := resolve("child", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowDefault || !write || !reflect.DeepEqual(allow, []string{"read"}) || !reflect.DeepEqual(deny, []string{"open"}) {
+		t.Errorf("resolve(child) = %v, %v, %v, %v, want true, true, [read], [open]", allowDefault, write, allow, deny)
+	}
+	if _, _, _, _, err := resolve("loop", 0); err == nil {
+		t.Error("resolve(loop) did not fail")
+	}
+	if _, _, _, _, err := resolve("missing", 0); err == nil {
+		t.Error("resolve(missing) did not fail")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux && !amd64
+// +build linux,!amd64
+
+package seccomp
+
+import "syscall"
+
+// auditArch is not known on this architecture, so every command run in the
+// sandbox is killed immediately
+const auditArch = 0
+
+// syscallNumber is not supported on this architecture
+func syscallNumber(regs *syscall.PtraceRegs) int {
+	return -1
+}
+
+// syscalls is empty, as the syscall numbers on this architecture are not
+// known
+var syscalls = map[string]int{}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Merovius/go-tap"
+)
+
+// sanitizeFlags are the default flags for building the sanitizer-variant of a
+// testsuite. Errors found by UBSan abort the process, so, like the ones found
+// by ASan, they can be attributed to the test that was running
+const sanitizeFlags = "-fsanitize=address,undefined -fno-sanitize-recover=undefined -fno-omit-frame-pointer -g"
+
+var (
+	// asanRegexp matches the first line of a report of ASan or LSan, e.g.
+	// "==4711==ERROR: AddressSanitizer: heap-buffer-overflow on address ..."
+	asanRegexp = regexp.MustCompile(`^==\d+==ERROR: (\w+Sanitizer): (.*)$`)
+
+	// ubsanRegexp matches a report of UBSan, e.g.
+	// "/tmp/bor-1/t.cpp:5:7: runtime error: signed integer overflow: ..."
+	ubsanRegexp = regexp.MustCompile(`^(.+?):(\d+):(?:\d+:)? runtime error: (.*)$`)
+
+	// frameRegexp matches a frame with a source location in a stack trace,
+	// e.g. "    #0 0x4011f6 in main /tmp/bor-1/t.cpp:10:5"
+	frameRegexp = regexp.MustCompile(`^\s*#\d+ 0x[0-9a-f]+ in .* ([^\s:]+):(\d+)(?::\d+)?$`)
+)
+
+// sanitizerReport is an error found by a sanitizer
+type sanitizerReport struct {
+	Sanitizer string // e.g. "AddressSanitizer"
+	Kind      string // e.g. "heap-buffer-overflow"
+	File      string // The innermost location in the build-dir, if any
+	Line      int
+	Text      string // The complete report, including stack traces
+}
+
+// testline creates a failing test for the report, found while running test
+func (s sanitizerReport) testline(test string) *tap.Testline {
+	tl := &tap.Testline{
+		Description: fmt.Sprintf("%s: %s: %s", test, s.Sanitizer, s.Kind),
+		Diagnostic:  s.Text,
+	}
+	if s.File != "" {
+		tl.Yaml = formatYAML(map[string]string{"file": s.File, "line": strconv.Itoa(s.Line)})
+	}
+	return tl
+}
+
+// locate sets the location of the report to file:line, if file is in the
+// build-dir and no location is set yet. Relative names (as used by UBSan) are
+// relative to the build-dir
+func (s *sanitizerReport) locate(builddir, file, line string) {
+	if s.File != "" {
+		return
+	}
+	if dir := path.Dir(file); dir != "." && dir != path.Clean(builddir) {
+		return
+	}
+	s.File = path.Base(file)
+	s.Line, _ = strconv.Atoi(line)
+}
+
+// parseSanitizer extracts the reports of ASan, LSan and UBSan from the output
+// of a process. A report ends with its SUMMARY-line or at the start of the next
+// report
+func parseSanitizer(out, builddir string) []sanitizerReport {
+	var reports []sanitizerReport
+	var cur *sanitizerReport
+	var text []string
+
+	finish := func() {
+		if cur != nil {
+			cur.Text = strings.Join(text, "\n") + "\n"
+			reports = append(reports, *cur)
+		}
+		cur, text = nil, nil
+	}
+
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if m := asanRegexp.FindStringSubmatch(line); m != nil {
+			finish()
+			kind := m[2]
+			if i := strings.Index(kind, " on "); i >= 0 {
+				kind = kind[:i]
+			}
+			cur = &sanitizerReport{Sanitizer: m[1], Kind: kind}
+		} else if m := ubsanRegexp.FindStringSubmatch(line); m != nil {
+			finish()
+			kind := m[3]
+			if i := strings.Index(kind, ": "); i >= 0 {
+				kind = kind[:i]
+			}
+			cur = &sanitizerReport{Sanitizer: "UndefinedBehaviorSanitizer", Kind: kind}
+			cur.locate(builddir, m[1], m[2])
+		} else if cur == nil {
+			continue
+		} else if m := frameRegexp.FindStringSubmatch(line); m != nil {
+			cur.locate(builddir, m[1], m[2])
+		}
+
+		text = append(text, line)
+		if strings.HasPrefix(line, "SUMMARY: ") {
+			finish()
+		}
+	}
+	finish()
+	return reports
+}
+
+// runSanitized runs the sanitizer-variant of the testsuite spec, every test in
+// its own process, and adds every error found by the sanitizers as a failing
+// test to res. Tests failing without a report are ignored, because they
+// already fail in res
+func (r *runner) runSanitized(spec Suite, res *cmdResult) {
+	sr := r.runIsolated(Suite{Name: spec.Name + ".san", Isolate: "test"})
+	res.stats.add(sr.stats)
+
+	var tests []*tap.Testline
+	if sr.err != nil {
+		diag := sr.err.Error()
+		if len(sr.output) > 0 {
+			diag = fmt.Sprintf("%s\n%s", sr.err, sr.output)
+		}
+		tests = append(tests, &tap.Testline{Description: "Sanitizer", Diagnostic: diag})
+	} else {
+		for _, t := range sr.suite.Tests {
+			for _, rep := range parseSanitizer(t.Diagnostic, r.builddir) {
+				tests = append(tests, rep.testline(t.Description))
+			}
+		}
+	}
+
+	for _, tl := range tests {
+		tl.Num = uint(len(res.suite.Tests) + 1)
+		res.suite.Tests = append(res.suite.Tests, tl)
+		res.suite.Ok = false
+	}
+}
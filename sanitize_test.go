@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSanitizer(t *testing.T) {
+	tcs := []struct {
+		out  string
+		want []sanitizerReport
+	}{
+		{"", nil},
+		{"ok 1 - test\n", nil},
+		{
+			"==4711==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000014\n" +
+				"READ of size 4 at 0x602000000014 thread T0\n" +
+				"    #0 0x4011f6 in f(int*) /usr/include/c++/foo.h:3:1\n" +
+				"    #1 0x4011f6 in main /tmp/bd/x.cpp:10:5\n" +
+				"    #2 0x4011f6 in main /tmp/bd/y.cpp:12:5\n" +
+				"SUMMARY: AddressSanitizer: heap-buffer-overflow /tmp/bd/x.cpp:10:5 in main\n" +
+				"trailing output\n",
+			[]sanitizerReport{{
+				Sanitizer: "AddressSanitizer",
+				Kind:      "heap-buffer-overflow",
+				File:      "x.cpp",
+				Line:      10,
+				Text: "==4711==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000014\n" +
+					"READ of size 4 at 0x602000000014 thread T0\n" +
+					"    #0 0x4011f6 in f(int*) /usr/include/c++/foo.h:3:1\n" +
+					"    #1 0x4011f6 in main /tmp/bd/x.cpp:10:5\n" +
+					"    #2 0x4011f6 in main /tmp/bd/y.cpp:12:5\n" +
+					"SUMMARY: AddressSanitizer: heap-buffer-overflow /tmp/bd/x.cpp:10:5 in main\n",
+			}},
+		},
+		{
+			"    #0 0x4011f6 in main /tmp/bd/x.cpp:10\n" +
+				"==1==ERROR: LeakSanitizer: detected memory leaks\n" +
+				"    #0 0x4011f6 in main /tmp/bd/x.cpp:7\n" +
+				"x.cpp:5:7: runtime error: signed integer overflow: 2147483647 + 1\n",
+			[]sanitizerReport{
+				{
+					Sanitizer: "LeakSanitizer",
+					Kind:      "detected memory leaks",
+					File:      "x.cpp",
+					Line:      7,
+					Text:      "==1==ERROR: LeakSanitizer: detected memory leaks\n    #0 0x4011f6 in main /tmp/bd/x.cpp:7\n",
+				},
+				{
+					Sanitizer: "UndefinedBehaviorSanitizer",
+					Kind:      "signed integer overflow",
+					File:      "x.cpp",
+					Line:      5,
+					Text:      "x.cpp:5:7: runtime error: signed integer overflow: 2147483647 + 1\n",
+				},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		if got := parseSanitizer(tc.out, "/tmp/bd"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseSanitizer(%q) = %+v, want %+v", tc.out, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+
+	"github.com/Merovius/bor/sandbox"
+	"github.com/Merovius/go-tap"
+)
+
+// Scoring describes the penalties applied to the total score of a request.
+// How many points the tests are worth is given in the suites themselves
+type Scoring struct {
+	Warning    float64 `json:"warning"`     // Points deducted per compiler warning
+	Timeout    float64 `json:"timeout"`     // Points deducted per testsuite that timed out
+	MaxPenalty float64 `json:"max_penalty"` // Upper bound for the sum of all penalties. 0 means no bound
+}
+
+// Weight assigns points to the tests whose description matches Match. Match
+// is either the exact description or a glob as understood by path.Match (e.g.
+// "Exercise2Test::*"). The points are evenly distributed over all tests
+// matching it
+type Weight struct {
+	Match  string  `json:"match"`
+	Points float64 `json:"points"`
+}
+
+// score is the number of points reached in a suite or in the whole request
+type score struct {
+	Points float64 `json:"points"`
+	Max    float64 `json:"max"`
+}
+
+// weight returns the index of the weight used for a test with the given
+// description. The first matching weight is used. If no weight matches, it
+// returns -1
+func (s Suite) weight(desc string) int {
+	for i, w := range s.Weights {
+		if w.Match == desc {
+			return i
+		}
+		if m, _ := path.Match(w.Match, desc); m {
+			return i
+		}
+	}
+	return -1
+}
+
+// score calculates the score of the suite from its results. The Points of the
+// suite are evenly distributed over all tests not matched by any weight, the
+// points of a weight over the tests matched by it. The reachable points only
+// depend on the suite, not on the tests in the results, so a suite crashing
+// before running some (or all) of its tests can not reach more
+func (s Suite) score(res Testsuite) score {
+	var sc score
+
+	// The tests matched by every weight, the last element holds the rest
+	matched := make([][]*tap.Testline, len(s.Weights)+1)
+	for _, tl := range res.Tests {
+		i := s.weight(tl.Description)
+		if i < 0 {
+			i = len(s.Weights)
+		}
+		matched[i] = append(matched[i], tl)
+	}
+
+	for i, tests := range matched {
+		points := s.Points
+		if i < len(s.Weights) {
+			points = s.Weights[i].Points
+		}
+		sc.Max += points
+		for _, tl := range tests {
+			if tl.Ok {
+				sc.Points += points / float64(len(tests))
+			}
+		}
+	}
+
+	if s.Cap > 0 {
+		if sc.Max > s.Cap {
+			sc.Max = s.Cap
+		}
+		if sc.Points > s.Cap {
+			sc.Points = s.Cap
+		}
+	}
+	return sc
+}
+
+// timedOut returns whether the suite timed out as a whole or in one of its
+// tests, that run in their own process (e.g. with Isolate or in I/O suites)
+func timedOut(s suiteWrap) bool {
+	timeout := (sandbox.TimeoutError{}).Error()
+	if s.Error == timeout {
+		return true
+	}
+	for _, tl := range s.Suite.Tests {
+		if !tl.Ok && firstLine(tl.Diagnostic) == timeout {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply calculates the score of every suite in suites, deducts the penalties
+// and appends a suite named "Score" containing the total. specs are the suites
+// given in the request, build is the output of the build. Suites that are
+// given in the request but did not run (e.g. because the build failed) still
+// count towards the reachable points
+func (sc *Scoring) Apply(suites []suiteWrap, specs []Suite, build []byte) []suiteWrap {
+	var total score
+	timeouts := 0
+
+	for _, spec := range specs {
+		var s score
+		found := false
+		for i := range suites {
+			if suites[i].Name != spec.Name {
+				continue
+			}
+			found = true
+			s = spec.score(suites[i].Suite)
+			suites[i].Score = &score{s.Points, s.Max}
+			if timedOut(suites[i]) {
+				timeouts++
+			}
+		}
+		if !found {
+			s = spec.score(Testsuite{})
+		}
+		total.Points += s.Points
+		total.Max += s.Max
+	}
+
+	warnings := bytes.Count(build, []byte("warning:"))
+
+	wtest := &tap.Testline{Num: 1, Description: "Compiler warnings", Ok: warnings == 0}
+	ttest := &tap.Testline{Num: 2, Description: "Timeouts", Ok: timeouts == 0}
+	wpen := float64(warnings) * sc.Warning
+	tpen := float64(timeouts) * sc.Timeout
+	if !wtest.Ok {
+		wtest.Diagnostic = fmt.Sprintf("%d warnings, -%g points", warnings, wpen)
+	}
+	if !ttest.Ok {
+		ttest.Diagnostic = fmt.Sprintf("%d suites timed out, -%g points", timeouts, tpen)
+	}
+
+	penalty := wpen + tpen
+	if sc.MaxPenalty > 0 && penalty > sc.MaxPenalty {
+		penalty = sc.MaxPenalty
+	}
+	total.Points -= penalty
+	if total.Points < 0 {
+		total.Points = 0
+	}
+
+	wrap := suiteWrap{
+		Name:  "Score",
+		Suite: Testsuite{Ok: wtest.Ok && ttest.Ok, Tests: []*tap.Testline{wtest, ttest}},
+		Score: &total,
+	}
+	return append(suites, wrap)
+}
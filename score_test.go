@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Merovius/go-tap"
+)
+
+func TestScore(t *testing.T) {
+	spec := Suite{
+		Points: 2,
+		Weights: []Weight{
+			{Match: "Test::exact", Points: 3},
+			{Match: "Test::*", Points: 4},
+		},
+	}
+	tests := func(oks ...interface{}) Testsuite {
+		var s Testsuite
+		for i := 0; i < len(oks); i += 2 {
+			s.Tests = append(s.Tests, &tap.Testline{Description: oks[i].(string), Ok: oks[i+1].(bool)})
+		}
+		return s
+	}
+
+	tcs := []struct {
+		name string
+		res  Testsuite
+		want score
+	}{
+		{"no tests", tests(), score{0, 9}},
+		{"all passed", tests("Test::exact", true, "Test::a", true, "Test::b", true, "other", true), score{9, 9}},
+		{"some failed", tests("Test::exact", false, "Test::a", true, "Test::b", false, "other", true), score{4, 9}},
+		{"crashed early", tests("Test::a", true), score{4, 9}},
+	}
+	for _, tc := range tcs {
+		if got := spec.score(tc.res); got != tc.want {
+			t.Errorf("%s: score() = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	capped := spec
+	capped.Cap = 5
+	if got, want := capped.score(tcs[1].res), (score{5, 5}); got != want {
+		t.Errorf("capped score() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTimedOut(t *testing.T) {
+	tcs := []struct {
+		s    suiteWrap
+		want bool
+	}{
+		{suiteWrap{}, false},
+		{suiteWrap{Error: "Timeout"}, true},
+		{suiteWrap{Error: "exit status 1"}, false},
+		{suiteWrap{Suite: Testsuite{Tests: []*tap.Testline{{Diagnostic: "Timeout\noutput"}}}}, true},
+		{suiteWrap{Suite: Testsuite{Tests: []*tap.Testline{{Ok: true, Diagnostic: "Timeout"}}}}, false},
+		{suiteWrap{Suite: Testsuite{Tests: []*tap.Testline{{Diagnostic: "Wrong output:\nTimeout"}}}}, false},
+	}
+	for _, tc := range tcs {
+		if got := timedOut(tc.s); got != tc.want {
+			t.Errorf("timedOut(%+v) = %v, want %v", tc.s, got, tc.want)
+		}
+	}
+}
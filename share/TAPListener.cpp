@@ -1,43 +1,79 @@
+#include <algorithm>
+#include <cstdlib>
+#include <cstring>
 #include <iostream>
+#include <sstream>
+#include <string>
+#include <vector>
+#include <stdint.h>
+#include <sys/time.h>
 #include <cppunit/Exception.h>
 #include <cppunit/extensions/TestFactoryRegistry.h>
 #include <cppunit/Message.h>
 #include <cppunit/SourceLine.h>
 #include <cppunit/Test.h>
 #include <cppunit/TestFailure.h>
-#include <cppunit/TestRunner.h>
 #include <cppunit/TestResult.h>
 #include <cppunit/TestResultCollector.h>
 #include <cppunit/TestSuite.h>
 
-CppUnit::Test *global_suite;
-
 class TAPListener : public CppUnit::TestListener {
     public:
-        void startSuite(CppUnit::Test *suite);
         void startTest(CppUnit::Test *test);
         void addFailure(const CppUnit::TestFailure &failure);
         void endTest(CppUnit::Test *test);
 
     private:
         CppUnit::Message msg;
+        CppUnit::SourceLine source;
         bool success;
+        struct timeval start;
 };
 
-void TAPListener::startSuite(CppUnit::Test *suite) {
-    if (global_suite == suite)
-        return;
-    CppUnit::TestSuite *s = (CppUnit::TestSuite *)suite;
-    std::cout << "TAP version 13" << std::endl;
-    std::cout << "1.." << s->getChildTestCount() << std::endl;
+// Quote a string for use as a double-quoted scalar in YAML
+std::string quote(const std::string &str) {
+    std::ostringstream out;
+    out << '"';
+    for (std::string::size_type i = 0; i < str.size(); i++) {
+        unsigned char c = str[i];
+        switch (c) {
+            case '"': out << "\\\""; break;
+            case '\\': out << "\\\\"; break;
+            case '\n': out << "\\n"; break;
+            case '\t': out << "\\t"; break;
+            default:
+                if (c < 0x20 || c == 0x7f) {
+                    const char *hex = "0123456789abcdef";
+                    out << "\\x" << hex[c >> 4] << hex[c & 0xf];
+                } else {
+                    out << c;
+                }
+        }
+    }
+    out << '"';
+    return out.str();
+}
+
+// If str starts with prefix, followed by a colon, store everything after the
+// colon (without leading whitespace) in value
+bool detailValue(const std::string &str, const std::string &prefix, std::string &value) {
+    if (str.compare(0, prefix.size(), prefix) != 0)
+        return false;
+    std::string::size_type colon = str.find(':', prefix.size());
+    if (colon == std::string::npos)
+        return false;
+    std::string::size_type start = str.find_first_not_of(" \t", colon + 1);
+    value = (start == std::string::npos) ? "" : str.substr(start);
+    return true;
 }
 
 void TAPListener::startTest(CppUnit::Test *test) {
     success = true;
+    gettimeofday(&start, NULL);
 }
 
 void TAPListener::addFailure(const CppUnit::TestFailure &failure) {
-    CppUnit::SourceLine source = failure.sourceLine();
+    source = failure.sourceLine();
     CppUnit::Exception *exp = failure.thrownException();
     msg = exp->message();
 
@@ -45,21 +81,138 @@ void TAPListener::addFailure(const CppUnit::TestFailure &failure) {
 }
 
 void TAPListener::endTest(CppUnit::Test *test) {
+    struct timeval end;
+    gettimeofday(&end, NULL);
+    long duration = (end.tv_sec - start.tv_sec) * 1000000L + (end.tv_usec - start.tv_usec);
+
+    std::cout << (success ? "ok " : "not ok ") << test->getName() << std::endl;
+
+    // Give structured information about the test as a YAML-block, as
+    // described in TAP version 13
+    std::cout << "  ---" << std::endl;
+    std::cout << "  duration_us: " << duration << std::endl;
     if (success) {
-        std::cout << "ok " << test->getName() << std::endl;
+        std::cout << "  ..." << std::endl;
         return;
     }
-    std::cout << "not ok " << test->getName() << std::endl;
+    std::cout << "  message: " << quote(msg.shortDescription()) << std::endl;
+    if (source.isValid()) {
+        std::cout << "  file: " << quote(source.fileName()) << std::endl;
+        std::cout << "  line: " << source.lineNumber() << std::endl;
+    }
+    for (int i = 0; i < msg.detailCount(); i++) {
+        std::string value;
+        if (detailValue(msg.detailAt(i), "Expected", value)) {
+            std::cout << "  expected: " << quote(value) << std::endl;
+        } else if (detailValue(msg.detailAt(i), "Actual", value)) {
+            std::cout << "  actual: " << quote(value) << std::endl;
+        }
+    }
+    std::cout << "  ..." << std::endl;
+
     std::cout << "# " << msg.shortDescription() << std::endl;
     for (int i = 0; i < msg.detailCount(); i++) {
         std::cout << "# \t" << msg.detailAt(i) << std::endl;
     }
 }
 
+// Collect all tests below test, that are no suites themselves
+void collect(CppUnit::Test *test, std::vector<CppUnit::Test *> &tests) {
+    if (test->getChildTestCount() == 0) {
+        tests.push_back(test);
+        return;
+    }
+    for (int i = 0; i < test->getChildTestCount(); i++)
+        collect(test->getChildTestAt(i), tests);
+}
+
+// Whether the test called name is selected by sel. A test is selected by its
+// name and by the name of its fixture (i.e. "Fixture" selects "Fixture::Test")
+bool selects(const std::string &sel, const std::string &name) {
+    if (sel == name)
+        return true;
+    return name.size() > sel.size() + 2 && name.compare(0, sel.size(), sel) == 0 && name.compare(sel.size(), 2, "::") == 0;
+}
 
+// Parse a seed for shuffle. It has to be a number between 1 and 2^32-1
+bool parseSeed(const char *s, uint32_t &seed) {
+    char *end;
+    unsigned long n = std::strtoul(s, &end, 10);
+    if (*s == '\0' || *end != '\0' || n == 0 || n > 0xffffffffUL)
+        return false;
+    seed = n;
+    return true;
+}
+
+// Shuffle the tests with the Fisher-Yates shuffle, using xorshift32 seeded with
+// seed. Unlike std::random_shuffle, this gives the same order everywhere, so a
+// seed can be used to reproduce a failure
+void shuffle(std::vector<CppUnit::Test *> &tests, uint32_t seed) {
+    uint32_t x = seed;
+    for (std::vector<CppUnit::Test *>::size_type i = tests.size(); i > 1; i--) {
+        x ^= x << 13;
+        x ^= x >> 17;
+        x ^= x << 5;
+        std::swap(tests[i - 1], tests[x % i]);
+    }
+}
+
+// Usage: testsuite [--list] [--seed=N] [test|fixture...]
+//
+// Without arguments, all tests are run. Otherwise only the given tests and the
+// tests of the given fixtures are run, in the order they are given. With
+// --list, the names of the tests are printed one per line instead of running
+// them. This is used by bor to run every test in its own process. With
+// --seed (or the environment variable BOR_SEED), the tests are run in a random
+// order, to uncover dependencies between them. The seed is printed as a comment
 int main(int argc, char* argv[]) {
     // Get the top level suite from the registry
-    global_suite = CppUnit::TestFactoryRegistry::getRegistry().makeTest();
+    CppUnit::Test *suite = CppUnit::TestFactoryRegistry::getRegistry().makeTest();
+
+    std::vector<CppUnit::Test *> all;
+    collect(suite, all);
+
+    bool list = false;
+    uint32_t seed = 0;
+    std::vector<std::string> sel;
+    if (const char *env = std::getenv("BOR_SEED")) {
+        if (!parseSeed(env, seed)) {
+            std::cerr << "Invalid BOR_SEED: " << env << std::endl;
+            return 2;
+        }
+    }
+    for (int i = 1; i < argc; i++) {
+        if (std::strcmp(argv[i], "--list") == 0) {
+            list = true;
+        } else if (std::strncmp(argv[i], "--seed=", 7) == 0) {
+            if (!parseSeed(argv[i] + 7, seed)) {
+                std::cerr << "Invalid seed: " << argv[i] + 7 << std::endl;
+                return 2;
+            }
+        } else {
+            sel.push_back(argv[i]);
+        }
+    }
+
+    std::vector<CppUnit::Test *> tests;
+    if (sel.empty()) {
+        tests = all;
+    }
+    for (std::vector<std::string>::size_type i = 0; i < sel.size(); i++) {
+        for (std::vector<CppUnit::Test *>::size_type j = 0; j < all.size(); j++) {
+            if (selects(sel[i], all[j]->getName()))
+                tests.push_back(all[j]);
+        }
+    }
+
+    if (seed != 0)
+        shuffle(tests, seed);
+
+    if (list) {
+        for (std::vector<CppUnit::Test *>::size_type i = 0; i < tests.size(); i++)
+            std::cout << tests[i]->getName() << std::endl;
+        return 0;
+    }
 
     // Create the event manager and test controller
     CppUnit::TestResult controller;
@@ -68,13 +221,15 @@ int main(int argc, char* argv[]) {
     TAPListener listener;
     controller.addListener(&listener);
 
-    // Adds the test to the list of test to run
-    CppUnit::TestRunner runner;
-    runner.addTest(global_suite);
+    std::cout << "TAP version 13" << std::endl;
+    std::cout << "1.." << tests.size() << std::endl;
+    if (seed != 0)
+        std::cout << "# Seed: " << seed << std::endl;
 
     // Run the tests.
-    const std::string path = "";
-    runner.run(controller, path);
+    for (std::vector<CppUnit::Test *>::size_type i = 0; i < tests.size(); i++)
+        tests[i]->run(&controller);
 
+    delete suite;
     return 0;
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// testRuns collects the results of a single test over all runs of validate
+type testRuns struct {
+	suite, test string
+	passed      int
+	diagnostic  string // Of the last failure
+	durations   []time.Duration
+}
+
+// validate implements the subcommand "validate": It runs a request (e.g. with
+// the testsuites of an assignment and the reference solution) locally, several
+// times, and reports tests that fail, are flaky or slow. It returns the exit
+// status
+func validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	runs := fs.Int("runs", 5, "How often to run the testsuites")
+	slow := fs.Float64("slow", 0.5, "Report tests (and suites) taking longer than this fraction of their timeout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bor [-config FILE] validate [-runs N] [-slow FRACTION] REQUEST")
+		fmt.Fprintln(os.Stderr, "REQUEST is a JSON-file in the format sent to the server, \"-\" for stdin")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *runs < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	msg, err := readRequest(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// The request may restrict the time of a run, like for the server
+	var timeout time.Duration
+	if msg.Timeout != "" {
+		if timeout, err = time.ParseDuration(msg.Timeout); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid timeout:", err)
+			return 2
+		}
+	}
+
+	// Suites are slow relative to the timeout of their runs
+	timeouts := make(map[string]time.Duration)
+	for _, spec := range msg.Suites {
+		timeouts[spec.Name] = suiteTimeout(spec)
+	}
+	timeoutOf := func(suite string) time.Duration {
+		if d, ok := timeouts[suite]; ok {
+			return d
+		}
+		return conf.TestTimeout
+	}
+	limitOf := func(suite string) time.Duration {
+		return time.Duration(float64(timeoutOf(suite)) * *slow)
+	}
+
+	var order []string
+	tests := make(map[string]*testRuns)
+	suiteErrs := make(map[string]string)
+	var slowSuites []string
+
+	for i := 0; i < *runs; i++ {
+		ctx, cancel := context.Background(), func() {}
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		suites, err := runRequest(ctx, msg)
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not create buildpath:", err)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "Run %d/%d finished\n", i+1, *runs)
+
+		for _, s := range suites {
+			if s.Error != "" {
+				suiteErrs[s.Name] = s.Error
+				continue
+			}
+			var total time.Duration
+			for _, tl := range s.Suite.Tests {
+				key := s.Name + "\x00" + tl.Description
+				t, ok := tests[key]
+				if !ok {
+					t = &testRuns{suite: s.Name, test: tl.Description}
+					tests[key] = t
+					order = append(order, key)
+				}
+				if tl.Ok {
+					t.passed++
+				} else {
+					t.diagnostic = tl.Diagnostic
+				}
+				if us, err := strconv.ParseInt(parseYAML(tl.Yaml)["duration_us"], 10, 64); err == nil {
+					d := time.Duration(us) * time.Microsecond
+					t.durations = append(t.durations, d)
+					total += d
+				}
+			}
+			if limit := limitOf(s.Name); limit > 0 && total > limit && !contains(slowSuites, s.Name) {
+				slowSuites = append(slowSuites, s.Name)
+			}
+		}
+	}
+
+	failed := false
+	names := make([]string, 0, len(suiteErrs))
+	for name := range suiteErrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		failed = true
+		fmt.Printf("ERROR %s: %s\n", name, suiteErrs[name])
+	}
+	for _, key := range order {
+		t := tests[key]
+		switch {
+		case t.passed == 0:
+			failed = true
+			fmt.Printf("FAIL  %s: %s\n", t.suite, t.test)
+			printIndented(t.diagnostic)
+		case t.passed < *runs:
+			failed = true
+			fmt.Printf("FLAKY %s: %s (passed %d of %d runs)\n", t.suite, t.test, t.passed, *runs)
+			printIndented(t.diagnostic)
+		}
+		if limit := limitOf(t.suite); limit > 0 && len(t.durations) > 0 {
+			if m := median(t.durations); m > limit {
+				fmt.Printf("SLOW  %s: %s (median %v, timeout %v)\n", t.suite, t.test, m, timeoutOf(t.suite))
+			}
+		}
+	}
+	for _, name := range slowSuites {
+		fmt.Printf("SLOW  %s (the tests took more than %v in at least one run, timeout %v)\n", name, limitOf(name), timeoutOf(name))
+	}
+
+	if failed {
+		return 1
+	}
+	fmt.Printf("OK    %d tests passed in all %d runs\n", len(order), *runs)
+	return 0
+}
+
+// readRequest reads a request from the file name, or stdin if name is "-"
+func readRequest(name string) (*Message, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	msg := new(Message)
+	if err := json.NewDecoder(r).Decode(msg); err != nil {
+		return nil, fmt.Errorf("Could not parse JSON: %v", err)
+	}
+	return msg, nil
+}
+
+// printIndented prints s, indenting every line
+func printIndented(s string) {
+	for _, l := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		if l != "" {
+			fmt.Println("      " + l)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the YAML-block of a test, as written by TAPListener.cpp.
+// Only a flat mapping of keys to scalars is supported. Double-quoted scalars
+// are unquoted, everything else is returned verbatim
+func parseYAML(b []byte) map[string]string {
+	m := make(map[string]string)
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line == "---" || line == "..." {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+		val := strings.TrimSpace(line[i+1:])
+		if strings.HasPrefix(val, `"`) {
+			if uq, err := strconv.Unquote(val); err == nil {
+				val = uq
+			}
+		}
+		m[key] = val
+	}
+	return m
+}
+
+// formatYAML creates a YAML-block for a test, in the same format as written by
+// TAPListener.cpp, so it can be parsed by parseYAML. All values are written as
+// double-quoted scalars, the keys are sorted
+func formatYAML(m map[string]string) []byte {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString("  ---\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  %s: %s\n", k, strconv.Quote(m[k]))
+	}
+	b.WriteString("  ...\n")
+	return b.Bytes()
+}